@@ -0,0 +1,124 @@
+// Command bench measures how much smaller the binary record envelope is than the
+// JSON one it replaced. The I/O and durability modes are compared by the benchmarks
+// of package store (go test -bench).
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Kentoso/db-design-labs/internal/models"
+	"github.com/Kentoso/db-design-labs/internal/store"
+)
+
+type record struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+var (
+	slots   = flag.Int("slots", 5000, "slot count of the measured tables")
+	records = flag.Int("n", 2500, "records loaded to measure envelope sizes")
+	dir     = flag.String("dir", "", "directory for the measured files (default: a temporary directory)")
+)
+
+func init() {
+	store.RegisterType(16, record{})
+}
+
+func main() {
+	flag.Parse()
+	root := *dir
+	if root == "" {
+		tmp, err := os.MkdirTemp("", "kdb-bench-")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tempdir: %v\n", err)
+			os.Exit(1)
+		}
+		defer os.RemoveAll(tmp)
+		root = tmp
+	}
+
+	fmt.Printf("%-8s %8s %8s %8s %6s %12s %12s\n", "record", "data B", "json B", "binary B", "saved", "inline json", "inline bin")
+	for _, sh := range shapes {
+		if err := measureEnvelope(filepath.Join(root, "envelope-"+sh.name+".bin"), sh.name, sh.value); err != nil {
+			fmt.Fprintf(os.Stderr, "envelope %s: %v\n", sh.name, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// shapes are the kinds of records the envelope sizes are measured on: a type
+// registered with the store, one that is not, and the raw JSON the CLI stores.
+var shapes = []struct {
+	name  string
+	value func(i int) any
+}{
+	{"record", func(i int) any { return rec(i) }},
+	{"adset", func(i int) any {
+		age, country := "25-34", "UA"
+		return models.AdSet{ID: int64(i), Name: fmt.Sprintf("ad set %d", i), TargetAge: &age, TargetCountry: &country,
+			CampaignID: int64(i / 10), CreatedAt: time.Unix(1700000000+int64(i), 0).UTC()}
+	}},
+	{"raw", func(i int) any {
+		raw, _ := json.Marshal(rec(i))
+		return json.RawMessage(raw)
+	}},
+}
+
+// measureEnvelope loads *records values of one shape and compares the average
+// envelope the store wrote, as Stats reports it, with the JSON envelope of file
+// format 5. The inline columns are the largest JSON value that still fits in a
+// slot without overflow under each envelope.
+func measureEnvelope(path, name string, value func(i int) any) error {
+	_ = os.Remove(path)
+	db, err := store.Create(path, store.CreateOptions{Slots: *slots, Options: store.Options{ReapInterval: -1}})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var data, legacy int
+	tx := db.Begin()
+	for i := 0; i < *records; i++ {
+		v := value(i)
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		env, err := json.Marshal(struct {
+			Key  string          `json:"key"`
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}{key(i), fmt.Sprintf("%T", v), b})
+		if err != nil {
+			return err
+		}
+		data, legacy = data+len(b), legacy+len(env)
+		if err := tx.Insert(key(i), v); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	st, err := db.Stats()
+	if err != nil {
+		return err
+	}
+	n := float64(st.Occupied)
+	avgData, avgJSON, avgBin := float64(data)/n, float64(legacy)/n, float64(st.PayloadBytes)/n
+	fmt.Printf("%-8s %8.1f %8.1f %8.1f %5.1f%% %12.0f %12.0f\n", name, avgData, avgJSON, avgBin,
+		100*(1-avgBin/avgJSON), store.PayloadCap-(avgJSON-avgData), store.PayloadCap-(avgBin-avgData))
+	return nil
+}
+
+func key(i int) string { return fmt.Sprintf("client:%d", i) }
+
+func rec(i int) record {
+	return record{ID: i, Name: fmt.Sprintf("client %d", i), Email: fmt.Sprintf("client%d@example.com", i)}
+}
@@ -0,0 +1,30 @@
+package store
+
+// backwardShift empties the slot at hole and closes the gap: every following entry
+// of the cluster that may legally sit at the hole (its home is not cyclically
+// inside (hole, j]) is moved back into it, and the slot it left becomes the new
+// hole. The walk ends at the first empty slot, which is where lookups stop anyway.
+// Records keep their version and overflow chain; only the slot holding them changes.
+// Caller runs it inside db.write.
+func (db *DB) backwardShift(hole int) error {
+	for n := 1; n < db.slots; n++ {
+		j := (hole + n) % db.slots
+		s, err := db.readSlot(j)
+		if err != nil {
+			return err
+		}
+		if s.state != StateOcc {
+			break
+		}
+		// Distance from the entry's home to j versus from the hole to j: the entry
+		// may move only if the hole lies on its probe path.
+		if db.probe.Distance(s.hash, j) < n {
+			continue
+		}
+		if err := db.writeSlot(hole, s); err != nil {
+			return err
+		}
+		hole, n = j, 0
+	}
+	return db.writeSlot(hole, slot{state: StateEmpty})
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+// keysHomedAt returns n keys whose probe sequence in db starts at slot home.
+func keysHomedAt(db *DB, home, n int) []string {
+	var keys []string
+	for i := 0; len(keys) < n; i++ {
+		key := fmt.Sprintf("h%d-%d", home, i)
+		for idx := range db.probe.Probe(db.hashKey(key)) {
+			if idx == home {
+				keys = append(keys, key)
+			}
+			break
+		}
+	}
+	return keys
+}
+
+// layout returns the key stored in each slot of db, "" for free slots, and
+// fails on tombstones.
+func layout(t *testing.T, db *DB) []string {
+	t.Helper()
+	out := make([]string, db.slots)
+	for i := range out {
+		d, err := db.SlotDetail(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.State == StateDeleted {
+			t.Fatalf("slot %d holds a tombstone", i)
+		}
+		out[i] = d.Key
+	}
+	return out
+}
+
+// backshiftOpts is a table that never resizes, so the tests control its layout.
+var backshiftOpts = CreateOptions{
+	Slots:      31,
+	DeleteMode: DeleteBackwardShift,
+	Options:    Options{MaxLoadFactor: -1},
+}
+
+func insertAll(t *testing.T, db *DB, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if err := db.Insert(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func wantLayout(t *testing.T, db *DB, want map[int]string) {
+	t.Helper()
+	got := layout(t, db)
+	for idx, key := range want {
+		if got[idx] != key {
+			t.Fatalf("slot %d holds %q, want %q (layout %q)", idx, got[idx], key, got)
+		}
+	}
+	for _, key := range want {
+		if key == "" {
+			continue
+		}
+		var v string
+		if found, err := db.Select(key, &v); err != nil || !found || v != key {
+			t.Fatalf("%s: found=%v value=%q err=%v", key, found, v, err)
+		}
+	}
+}
+
+func TestBackwardShiftMidRun(t *testing.T) {
+	db := createTestDB(t, backshiftOpts)
+	const h = 10
+	ks := keysHomedAt(db, h, 3)
+	d := keysHomedAt(db, h+1, 1)[0]
+	e := keysHomedAt(db, h+5, 1)[0]
+	insertAll(t, db, ks[0], ks[1], ks[2], d, e)
+	wantLayout(t, db, map[int]string{h: ks[0], h + 1: ks[1], h + 2: ks[2], h + 3: d, h + 5: e})
+
+	if found, err := db.Delete(ks[1]); err != nil || !found {
+		t.Fatalf("Delete: %v, %v", found, err)
+	}
+	// Both followers move back one slot; the run ends at the empty slot before e,
+	// which stays where it is.
+	wantLayout(t, db, map[int]string{h: ks[0], h + 1: ks[2], h + 2: d, h + 3: "", h + 5: e})
+	if st, err := db.Stats(); err != nil || st.Deleted != 0 {
+		t.Fatalf("Stats: %d tombstones, %v", st.Deleted, err)
+	}
+}
+
+func TestBackwardShiftKeepsEntriesAtHome(t *testing.T) {
+	db := createTestDB(t, backshiftOpts)
+	const h = 10
+	ks := keysHomedAt(db, h, 2)
+	e := keysHomedAt(db, h+2, 1)[0]
+	insertAll(t, db, ks[0], ks[1], e)
+
+	if _, err := db.Delete(ks[0]); err != nil {
+		t.Fatal(err)
+	}
+	// e sits at its home slot: moving it back would hide it from lookups.
+	wantLayout(t, db, map[int]string{h: ks[1], h + 1: "", h + 2: e})
+}
+
+func TestBackwardShiftWrapsAround(t *testing.T) {
+	db := createTestDB(t, backshiftOpts)
+	last := db.slots - 1
+	ks := keysHomedAt(db, last, 2)
+	z := keysHomedAt(db, 0, 1)[0]
+	insertAll(t, db, ks[0], ks[1], z)
+	wantLayout(t, db, map[int]string{last: ks[0], 0: ks[1], 1: z})
+
+	if _, err := db.Delete(ks[0]); err != nil {
+		t.Fatal(err)
+	}
+	wantLayout(t, db, map[int]string{last: ks[1], 0: z, 1: ""})
+}
@@ -0,0 +1,267 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Backup copies the DB file as it was at one instant while readers and writers
+// carry on. It takes the snapshot under the exclusive lock, which only costs
+// recording the file size, and then copies the file a chunk at a time under the
+// read lock. Writes committed meanwhile are copy-on-write for the backup: before
+// a batch overwrites a page the copy has not reached yet, commit saves the page
+// as it was, and the copy uses that saved page instead. A Clear or a resize
+// replaces the whole table, so it saves every page not copied yet.
+//
+// The copy is a DB file of its own. Secondary indexes and the key index are not
+// part of it; they are rebuilt from the table after Restore.
+const (
+	backupChunk   = 64 * SlotSize
+	restoreSuffix = ".restore"
+)
+
+// backup is the state of the Backup in progress. Its fields are guarded by db.mu.
+type backup struct {
+	// size is the file size at the snapshot; pages past it are not copied.
+	size int64
+	// copied is how far the copy has got; pages before it need no saving.
+	copied int64
+	// saved holds the snapshot contents of pages changed since, by offset.
+	saved map[int64][]byte
+}
+
+// preserve saves the snapshot contents of the pages b is about to change for the
+// backup in progress. Caller holds db.mu exclusively.
+func (db *DB) preserve(b *batch) error {
+	bk := db.backup
+	if bk == nil {
+		return nil
+	}
+	if b.reset >= 0 {
+		return db.preserveRest()
+	}
+	for _, off := range b.order {
+		if err := db.preservePage(off, len(b.pages[off])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preserveRest saves every page the backup in progress has yet to copy.
+// Caller holds db.mu exclusively.
+func (db *DB) preserveRest() error {
+	bk := db.backup
+	if bk == nil {
+		return nil
+	}
+	for off := bk.copied; off < bk.size; off += SlotSize {
+		if err := db.preservePage(off, SlotSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) preservePage(off int64, n int) error {
+	bk := db.backup
+	if off < bk.copied || off >= bk.size {
+		return nil
+	}
+	if _, ok := bk.saved[off]; ok {
+		return nil
+	}
+	page := make([]byte, min(int64(n), bk.size-off))
+	if _, err := db.readCommitted(page, off); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	bk.saved[off] = page
+	return nil
+}
+
+// Backup writes a consistent copy of the DB file, as of the moment it is called,
+// to w. Reads and writes keep going while it runs; only one Backup runs at a time.
+func (db *DB) Backup(w io.Writer) error {
+	db.bmu.Lock()
+	defer db.bmu.Unlock()
+
+	db.mu.Lock()
+	if db.f == nil {
+		db.mu.Unlock()
+		return os.ErrClosed
+	}
+	bk := &backup{size: db.hdr.fileSize(), saved: make(map[int64][]byte)}
+	db.backup = bk
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.backup = nil
+		db.mu.Unlock()
+	}()
+
+	buf := make([]byte, backupChunk)
+	for off := int64(0); off < bk.size; {
+		chunk := buf[:min(int64(len(buf)), bk.size-off)]
+		if err := db.copyChunk(bk, chunk, off); err != nil {
+			return err
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		off += int64(len(chunk))
+	}
+	return nil
+}
+
+// copyChunk fills chunk with the snapshot contents at off and moves the copy past it.
+func (db *DB) copyChunk(bk *backup, chunk []byte, off int64) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.f == nil {
+		return os.ErrClosed
+	}
+	if len(bk.saved) == 0 {
+		if _, err := db.readCommitted(chunk, off); err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+	} else {
+		for at := 0; at < len(chunk); at += SlotSize {
+			page := chunk[at:min(at+SlotSize, len(chunk))]
+			if saved, ok := bk.saved[off+int64(at)]; ok {
+				copy(page, saved)
+				// Only the backup touches saved while db.mu is shared.
+				delete(bk.saved, off+int64(at))
+				continue
+			}
+			if _, err := db.readCommitted(page, off+int64(at)); err != nil {
+				return fmt.Errorf("backup: %w", err)
+			}
+		}
+	}
+	bk.copied = off + int64(len(chunk))
+	return nil
+}
+
+// Snapshot writes a consistent copy of the DB file to path, as Backup does,
+// replacing path only once the copy is complete and synced. The copy can be
+// opened as a DB of its own or put back with Restore.
+func (db *DB) Snapshot(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(f, 1<<20)
+	err = db.Backup(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(path)
+}
+
+// Restore replaces the DB file at path with the backup at src, after checking
+// that src has a valid header and the size it describes. The DB must not be open
+// anywhere: Restore takes its lock, waiting up to lockTimeout, and fails with
+// ErrLocked otherwise. The WAL of the replaced file is dropped, and the key index
+// and secondary indexes are rebuilt from the restored table on the next Open.
+func Restore(path, src string, lockTimeout time.Duration) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if _, err := readHeader(in); err != nil {
+		return fmt.Errorf("restore %s: %w", src, err)
+	}
+	lock, err := lockDB(path, false, lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	// A reader that found no <db>.lock holds a lock on the DB file instead.
+	if cur, err := os.Open(path); err == nil {
+		defer cur.Close()
+		if err := lockFile(cur, path, false, lockTimeout); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	tmp := path + restoreSuffix
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, io.NewSectionReader(in, 0, 1<<62))
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	// The log belongs to the file being replaced and must never be replayed
+	// onto the backup.
+	if err := os.Remove(path + walSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := syncDir(path); err != nil {
+		return err
+	}
+	if err := os.Remove(path + keyIndexSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return markCatalogDirty(path)
+}
+
+// markCatalogDirty flags the secondary indexes of the DB at path for a rebuild
+// on the next Open.
+func markCatalogDirty(path string) error {
+	b, err := os.ReadFile(path + catalogSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var cat catalog
+	if err := json.Unmarshal(b, &cat); err != nil {
+		return fmt.Errorf("index catalog: %w", err)
+	}
+	cat.Clean = false
+	if b, err = json.MarshalIndent(cat, "", "  "); err != nil {
+		return err
+	}
+	tmp := path + catalogSuffix + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path+catalogSuffix)
+}
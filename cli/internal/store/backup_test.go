@@ -0,0 +1,145 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// pausingWriter holds up the first Write until release is closed, after
+// signalling paused.
+type pausingWriter struct {
+	bytes.Buffer
+	once            sync.Once
+	paused, release chan struct{}
+}
+
+func (w *pausingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.paused)
+		<-w.release
+	})
+	return w.Buffer.Write(p)
+}
+
+func TestBackupIsPointInTime(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Create(filepath.Join(dir, "db.bin"), CreateOptions{Slots: 257, Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const n = 180
+	for i := 0; i < n; i++ {
+		if err := db.Insert(fmt.Sprintf("k%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := &pausingWriter{paused: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan error)
+	go func() { done <- db.Backup(w) }()
+	<-w.paused
+	// The first chunk is copied; change the rest of the table under the backup
+	// and grow it past its load factor.
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if i%4 == 0 {
+			_, err = db.Delete(key)
+		} else {
+			err = db.Update(key, -i)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := n; i < 2*n; i++ {
+		if err := db.Insert(fmt.Sprintf("k%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if h := db.Header(); h.Resizes == 0 {
+		t.Fatal("the writes did not resize the table")
+	}
+	close(w.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "backup.bin")
+	if err := os.WriteFile(path, w.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bk, err := Open(path, OpenOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bk.Close()
+	if h := bk.Header(); h.Resizes != 0 || h.Slots != 257 {
+		t.Fatalf("backup header: %d resizes, %d slots", h.Resizes, h.Slots)
+	}
+	st, err := bk.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Occupied != n || st.Corrupt != 0 {
+		t.Fatalf("backup has %d records and %d corrupt slots, want %d and 0", st.Occupied, st.Corrupt, n)
+	}
+	for i := 0; i < 2*n; i++ {
+		var v int
+		found, err := bk.Select(fmt.Sprintf("k%d", i), &v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != (i < n) || (found && v != i) {
+			t.Fatalf("k%d in backup: found=%v value=%d", i, found, v)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db.bin")
+	snap := filepath.Join(dir, "snap.bin")
+	db, err := Create(path, CreateOptions{Slots: 31, Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Snapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("b", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(path, snap, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Restore over an open DB: %v", err)
+	}
+	var v int
+	if found, err := db.Select("b", &v); err != nil || !found {
+		t.Fatalf("refused Restore touched the DB: found=%v, %v", found, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(path, snap, 0); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(path, OpenOptions{Options: Options{ReapInterval: -1}}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for key, want := range map[string]bool{"a": true, "b": false} {
+		if found, err := db.Select(key, &v); err != nil || found != want {
+			t.Fatalf("%s after Restore: found=%v, %v", key, found, err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// The benchmarks compare the I/O modes (pread, mmap and the buffer pool) on a
+// table of benchSlots slots, loaded with benchRecords records where they read,
+// and the durability modes on insert throughput:
+//
+//	go test -run '^$' -bench . -benchmem ./internal/store
+const (
+	benchSlots   = 5000
+	benchRecords = 2500
+)
+
+type benchRecord struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+var ioModes = []struct {
+	name string
+	opts Options
+}{
+	{"pread", Options{ReapInterval: -1}},
+	{"mmap", Options{ReapInterval: -1, Mmap: true}},
+	{"pool", Options{ReapInterval: -1, CachePages: 256}},
+}
+
+// benchDB creates a fresh table holding the first n records.
+func benchDB(b *testing.B, opts Options, n int) *DB {
+	b.Helper()
+	db, err := Create(filepath.Join(b.TempDir(), "bench.bin"), CreateOptions{Slots: benchSlots, Options: opts})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = db.Close() })
+	tx := db.Begin()
+	for i := 0; i < n; i++ {
+		if err := tx.Insert(benchKey(i), benchRec(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	return db
+}
+
+func benchKey(i int) string { return fmt.Sprintf("client:%d", i) }
+
+func benchRec(i int) benchRecord {
+	return benchRecord{ID: i, Name: fmt.Sprintf("client %d", i), Email: fmt.Sprintf("client%d@example.com", i)}
+}
+
+func BenchmarkSelect(b *testing.B) {
+	for _, m := range ioModes {
+		b.Run(m.name, func(b *testing.B) {
+			db := benchDB(b, m.opts, benchRecords)
+			var r benchRecord
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.Select(benchKey(i%benchRecords), &r); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkInsert(b *testing.B) {
+	for _, m := range ioModes {
+		b.Run(m.name, func(b *testing.B) {
+			db := benchDB(b, m.opts, 0)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.Insert(benchKey(i), benchRec(i)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkStats reads every slot header, as Stats and States do.
+func BenchmarkStats(b *testing.B) {
+	for _, m := range ioModes {
+		b.Run(m.name, func(b *testing.B) {
+			db := benchDB(b, m.opts, benchRecords)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.Stats(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkScan decodes every live record through a cursor.
+func BenchmarkScan(b *testing.B) {
+	for _, m := range ioModes {
+		b.Run(m.name, func(b *testing.B) {
+			db := benchDB(b, m.opts, benchRecords)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c := db.Scan()
+				for range c.All() {
+				}
+				if err := c.Err(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+var durabilities = []struct {
+	name string
+	opts Options
+}{
+	{"none", Options{ReapInterval: -1}},
+	{"sync", Options{ReapInterval: -1, Durability: DurabilitySync}},
+	{"group", Options{ReapInterval: -1, Durability: DurabilityGroup}},
+}
+
+// BenchmarkInsertDurable inserts from a single writer under each durability mode.
+func BenchmarkInsertDurable(b *testing.B) {
+	for _, m := range durabilities {
+		b.Run(m.name, func(b *testing.B) {
+			db := benchDB(b, m.opts, 0)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.Insert(benchKey(i), benchRec(i)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "ops/s")
+		})
+	}
+}
+
+// BenchmarkInsertParallel inserts from several writers per CPU at once, so that
+// group commit has writers to batch; -cpu varies the number of CPUs.
+func BenchmarkInsertParallel(b *testing.B) {
+	for _, m := range durabilities {
+		for _, writers := range []int{1, 4, 16} {
+			b.Run(fmt.Sprintf("%s/writers=%d", m.name, writers), func(b *testing.B) {
+				db := benchDB(b, m.opts, 0)
+				var next atomic.Int64
+				b.SetParallelism(writers)
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						i := int(next.Add(1))
+						if err := db.Insert(benchKey(i), benchRec(i)); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				})
+				b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "ops/s")
+			})
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// btree is a B+tree file mapping keys to uint32 values, kept in key order.
+// Page 0 is the meta page; every other page is a node:
+//
+//	meta:     magic(uint32) | root(uint32) | pages(uint32) | count(uint64) | clean(uint8)
+//	node:     kind(uint8) | n(uint16) | next(uint32, leaves: right sibling, 0 = none) | entries
+//	leaf:     n x ( klen(uint16) | key | value(uint32) )
+//	internal: child0(uint32) | n x ( klen(uint16) | key | child(uint32) )
+//
+// In an internal node, child i+1 holds the keys >= key i. Deleting never merges
+// nodes: a leaf may end up empty and is simply skipped by scans. Rebuilding with
+// load packs the tree again.
+type btree struct {
+	f     *os.File
+	root  uint32
+	pages uint32
+	count uint64
+	// readOnly trees are never written, not even on close.
+	readOnly bool
+}
+
+const (
+	btreePageSize = 4096
+	btreeMagic    = 0x3154424b // "KBT1"
+	btreeNodeHdr  = 1 + 2 + 4
+
+	// MaxIndexedKeyLen bounds the keys a B+tree can hold, so that any node split
+	// leaves both halves within a page.
+	MaxIndexedKeyLen = 1024
+
+	nodeLeaf     = 1
+	nodeInternal = 2
+)
+
+var errBadBTree = errors.New("bad b+tree file")
+
+type node struct {
+	id       uint32
+	leaf     bool
+	next     uint32
+	keys     []string
+	values   []uint32 // leaves
+	children []uint32 // internal nodes, len(keys)+1
+}
+
+func (n *node) size() int {
+	sz := btreeNodeHdr
+	if !n.leaf {
+		sz += 4
+	}
+	for _, k := range n.keys {
+		sz += 2 + len(k) + 4
+	}
+	return sz
+}
+
+func (n *node) encode() []byte {
+	buf := make([]byte, btreePageSize)
+	buf[0] = nodeInternal
+	if n.leaf {
+		buf[0] = nodeLeaf
+	}
+	binary.LittleEndian.PutUint16(buf[1:3], uint16(len(n.keys)))
+	binary.LittleEndian.PutUint32(buf[3:7], n.next)
+	p := buf[btreeNodeHdr:btreeNodeHdr]
+	if !n.leaf {
+		p = binary.LittleEndian.AppendUint32(p, n.children[0])
+	}
+	for i, k := range n.keys {
+		p = binary.LittleEndian.AppendUint16(p, uint16(len(k)))
+		p = append(p, k...)
+		if n.leaf {
+			p = binary.LittleEndian.AppendUint32(p, n.values[i])
+		} else {
+			p = binary.LittleEndian.AppendUint32(p, n.children[i+1])
+		}
+	}
+	return buf
+}
+
+func decodeNode(id uint32, buf []byte) (*node, error) {
+	n := &node{id: id, leaf: buf[0] == nodeLeaf, next: binary.LittleEndian.Uint32(buf[3:7])}
+	if buf[0] != nodeLeaf && buf[0] != nodeInternal {
+		return nil, fmt.Errorf("%w: page %d has kind %d", errBadBTree, id, buf[0])
+	}
+	count := int(binary.LittleEndian.Uint16(buf[1:3]))
+	p := buf[btreeNodeHdr:]
+	u32 := func() (uint32, bool) {
+		if len(p) < 4 {
+			return 0, false
+		}
+		v := binary.LittleEndian.Uint32(p)
+		p = p[4:]
+		return v, true
+	}
+	if !n.leaf {
+		c, ok := u32()
+		if !ok {
+			return nil, fmt.Errorf("%w: page %d truncated", errBadBTree, id)
+		}
+		n.children = append(n.children, c)
+	}
+	for range count {
+		if len(p) < 2 {
+			return nil, fmt.Errorf("%w: page %d truncated", errBadBTree, id)
+		}
+		kl := int(binary.LittleEndian.Uint16(p))
+		p = p[2:]
+		if len(p) < kl {
+			return nil, fmt.Errorf("%w: page %d truncated", errBadBTree, id)
+		}
+		n.keys = append(n.keys, string(p[:kl]))
+		p = p[kl:]
+		v, ok := u32()
+		if !ok {
+			return nil, fmt.Errorf("%w: page %d truncated", errBadBTree, id)
+		}
+		if n.leaf {
+			n.values = append(n.values, v)
+		} else {
+			n.children = append(n.children, v)
+		}
+	}
+	return n, nil
+}
+
+// openBTree opens the tree file at path, creating an empty tree if it does not
+// exist. clean reports whether it was last closed with close(true).
+func openBTree(path string) (t *btree, clean bool, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, false, err
+	}
+	t = &btree{f: f}
+	buf := make([]byte, btreePageSize)
+	if _, err := f.ReadAt(buf, 0); err != nil || binary.LittleEndian.Uint32(buf[0:4]) != btreeMagic {
+		// New or unreadable: start empty; the caller rebuilds it.
+		if err := t.reset(); err != nil {
+			_ = f.Close()
+			return nil, false, err
+		}
+		return t, false, nil
+	}
+	t.root = binary.LittleEndian.Uint32(buf[4:8])
+	t.pages = binary.LittleEndian.Uint32(buf[8:12])
+	t.count = binary.LittleEndian.Uint64(buf[12:20])
+	clean = buf[20] == 1
+	// Mark it in use until close: a crash leaves it dirty.
+	if err := t.writeMeta(false); err != nil {
+		_ = f.Close()
+		return nil, false, err
+	}
+	return t, clean, f.Sync()
+}
+
+func (t *btree) writeMeta(clean bool) error {
+	buf := make([]byte, btreePageSize)
+	binary.LittleEndian.PutUint32(buf[0:4], btreeMagic)
+	binary.LittleEndian.PutUint32(buf[4:8], t.root)
+	binary.LittleEndian.PutUint32(buf[8:12], t.pages)
+	binary.LittleEndian.PutUint64(buf[12:20], t.count)
+	if clean {
+		buf[20] = 1
+	}
+	_, err := t.f.WriteAt(buf, 0)
+	return err
+}
+
+// openBTreeReadOnly opens the tree file at path for reading only, leaving it
+// untouched. A missing or unreadable tree reads as not clean.
+func openBTreeReadOnly(path string) (t *btree, clean bool, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	buf := make([]byte, btreePageSize)
+	if _, err := f.ReadAt(buf, 0); err != nil || binary.LittleEndian.Uint32(buf[0:4]) != btreeMagic {
+		_ = f.Close()
+		return nil, false, nil
+	}
+	t = &btree{f: f, readOnly: true}
+	t.root = binary.LittleEndian.Uint32(buf[4:8])
+	t.pages = binary.LittleEndian.Uint32(buf[8:12])
+	t.count = binary.LittleEndian.Uint64(buf[12:20])
+	return t, buf[20] == 1, nil
+}
+
+// close syncs the tree and closes it, marking it clean if asked to.
+func (t *btree) close(clean bool) error {
+	if t.readOnly {
+		return t.f.Close()
+	}
+	err := t.writeMeta(clean)
+	if serr := t.f.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (t *btree) read(id uint32) (*node, error) {
+	if id == 0 || id >= t.pages {
+		return nil, fmt.Errorf("%w: page %d out of range", errBadBTree, id)
+	}
+	buf := make([]byte, btreePageSize)
+	if _, err := t.f.ReadAt(buf, int64(id)*btreePageSize); err != nil {
+		return nil, err
+	}
+	return decodeNode(id, buf)
+}
+
+func (t *btree) write(n *node) error {
+	_, err := t.f.WriteAt(n.encode(), int64(n.id)*btreePageSize)
+	return err
+}
+
+func (t *btree) alloc() uint32 {
+	t.pages++
+	return t.pages - 1
+}
+
+// reset empties the tree, leaving a single empty leaf as the root.
+func (t *btree) reset() error {
+	if err := t.f.Truncate(0); err != nil {
+		return err
+	}
+	t.pages, t.count = 1, 0
+	t.root = t.alloc()
+	if err := t.write(&node{id: t.root, leaf: true}); err != nil {
+		return err
+	}
+	return t.writeMeta(false)
+}
+
+// descend returns the path of nodes from the root to the leaf that would hold key.
+func (t *btree) descend(key string) ([]*node, error) {
+	var path []*node
+	id := t.root
+	for {
+		n, err := t.read(id)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, n)
+		if n.leaf {
+			return path, nil
+		}
+		i, found := slices.BinarySearch(n.keys, key)
+		if found {
+			i++
+		}
+		id = n.children[i]
+	}
+}
+
+// put sets the value of key, adding it if absent.
+func (t *btree) put(key string, value uint32) error {
+	if len(key) > MaxIndexedKeyLen {
+		return fmt.Errorf("key longer than %d bytes cannot be indexed", MaxIndexedKeyLen)
+	}
+	path, err := t.descend(key)
+	if err != nil {
+		return err
+	}
+	leaf := path[len(path)-1]
+	i, found := slices.BinarySearch(leaf.keys, key)
+	if found {
+		if leaf.values[i] == value {
+			return nil
+		}
+		leaf.values[i] = value
+		return t.write(leaf)
+	}
+	leaf.keys = slices.Insert(leaf.keys, i, key)
+	leaf.values = slices.Insert(leaf.values, i, value)
+	t.count++
+
+	// Split overfull nodes bottom-up, pushing a separator into the parent.
+	for level := len(path) - 1; ; level-- {
+		n := path[level]
+		if n.size() <= btreePageSize {
+			return t.write(n)
+		}
+		sep, right := t.split(n)
+		if err := t.write(right); err != nil {
+			return err
+		}
+		if err := t.write(n); err != nil {
+			return err
+		}
+		if level == 0 {
+			root := &node{id: t.alloc(), keys: []string{sep}, children: []uint32{n.id, right.id}}
+			t.root = root.id
+			if err := t.write(root); err != nil {
+				return err
+			}
+			return t.writeMeta(false)
+		}
+		parent := path[level-1]
+		j, _ := slices.BinarySearch(parent.keys, sep)
+		parent.keys = slices.Insert(parent.keys, j, sep)
+		parent.children = slices.Insert(parent.children, j+1, right.id)
+	}
+}
+
+// split moves the upper half of n, by size, into a new right sibling and returns
+// the separator to insert into the parent.
+func (t *btree) split(n *node) (string, *node) {
+	half, at := 0, 0
+	for at < len(n.keys)-1 && half < n.size()/2 {
+		half += 2 + len(n.keys[at]) + 4
+		at++
+	}
+	right := &node{id: t.alloc(), leaf: n.leaf}
+	if n.leaf {
+		right.keys = slices.Clone(n.keys[at:])
+		right.values = slices.Clone(n.values[at:])
+		right.next, n.next = n.next, right.id
+		n.keys, n.values = n.keys[:at], n.values[:at]
+		return right.keys[0], right
+	}
+	// The middle key moves up instead of being copied.
+	sep := n.keys[at]
+	right.keys = slices.Clone(n.keys[at+1:])
+	right.children = slices.Clone(n.children[at+1:])
+	n.keys, n.children = n.keys[:at], n.children[:at+1]
+	return sep, right
+}
+
+// delete removes key and reports whether it was present.
+func (t *btree) delete(key string) (bool, error) {
+	path, err := t.descend(key)
+	if err != nil {
+		return false, err
+	}
+	leaf := path[len(path)-1]
+	i, found := slices.BinarySearch(leaf.keys, key)
+	if !found {
+		return false, nil
+	}
+	leaf.keys = slices.Delete(leaf.keys, i, i+1)
+	leaf.values = slices.Delete(leaf.values, i, i+1)
+	t.count--
+	return true, t.write(leaf)
+}
+
+// scan calls fn for each key >= from in order until fn returns false.
+func (t *btree) scan(from string, fn func(key string, value uint32) bool) error {
+	path, err := t.descend(from)
+	if err != nil {
+		return err
+	}
+	leaf := path[len(path)-1]
+	i, _ := slices.BinarySearch(leaf.keys, from)
+	for {
+		for ; i < len(leaf.keys); i++ {
+			if !fn(leaf.keys[i], leaf.values[i]) {
+				return nil
+			}
+		}
+		if leaf.next == 0 {
+			return nil
+		}
+		if leaf, err = t.read(leaf.next); err != nil {
+			return err
+		}
+		i = 0
+	}
+}
+
+// load replaces the content of the tree with keys and their values, which must be
+// sorted by key, packing the nodes full.
+func (t *btree) load(keys []string, values []uint32) error {
+	if err := t.reset(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, k := range keys {
+		if len(k) > MaxIndexedKeyLen {
+			return fmt.Errorf("key longer than %d bytes cannot be indexed", MaxIndexedKeyLen)
+		}
+	}
+
+	// level holds the nodes built on the current level and the smallest key under each.
+	type built struct {
+		id  uint32
+		min string
+	}
+	var level []built
+	leaf := &node{id: t.root, leaf: true}
+	for i, k := range keys {
+		if leaf.size()+2+len(k)+4 > btreePageSize {
+			next := &node{id: t.alloc(), leaf: true}
+			leaf.next = next.id
+			if err := t.write(leaf); err != nil {
+				return err
+			}
+			level = append(level, built{leaf.id, leaf.keys[0]})
+			leaf = next
+		}
+		leaf.keys = append(leaf.keys, k)
+		leaf.values = append(leaf.values, values[i])
+	}
+	if err := t.write(leaf); err != nil {
+		return err
+	}
+	level = append(level, built{leaf.id, leaf.keys[0]})
+	t.count = uint64(len(keys))
+
+	for len(level) > 1 {
+		var up []built
+		n := &node{id: t.alloc(), children: []uint32{level[0].id}}
+		nmin := level[0].min
+		for _, c := range level[1:] {
+			if n.size()+2+len(c.min)+4 > btreePageSize {
+				if err := t.write(n); err != nil {
+					return err
+				}
+				up = append(up, built{n.id, nmin})
+				n = &node{id: t.alloc(), children: []uint32{c.id}}
+				nmin = c.min
+				continue
+			}
+			n.keys = append(n.keys, c.min)
+			n.children = append(n.children, c.id)
+		}
+		if err := t.write(n); err != nil {
+			return err
+		}
+		level = append(up, built{n.id, nmin})
+	}
+	t.root = level[0].id
+	return t.writeMeta(false)
+}
@@ -0,0 +1,243 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// With Options.CachePages the DB file is read and written through a bounded
+// buffer pool of 4 KiB frames, each caching eight consecutive 512-byte pages of
+// the file. Frames are evicted with the CLOCK algorithm: every access sets a
+// reference bit, and the clock hand clears bits until it finds an unreferenced
+// frame. Committed pages are written into the pool and only marked dirty; they
+// reach the file when their frame is evicted, on Flush, and on every checkpoint,
+// which includes Close. Until then the WAL still holds them, so a crash loses
+// nothing. The pool is an alternative to Options.Mmap and cannot be combined with it.
+const (
+	poolFrameSize     = 4096
+	poolPagesPerFrame = poolFrameSize / SlotSize
+)
+
+var errPoolWithMmap = errors.New("Mmap and CachePages cannot be combined")
+
+type frame struct {
+	page  int64 // frame number in the file (offset / poolFrameSize); -1 when unused
+	ref   bool
+	dirty uint8 // bit i: the i-th 512-byte page differs from the file
+	data  [poolFrameSize]byte
+}
+
+// PoolStats are the buffer pool counters reported in Stats.
+type PoolStats struct {
+	Frames    int
+	Dirty     int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+type bufferPool struct {
+	// mu guards the frames: readers holding db.mu.RLock share the pool.
+	mu     sync.Mutex
+	f      *os.File
+	frames []frame
+	index  map[int64]int
+	hand   int
+	// size is the logical file size: the file on disk plus dirty pages past its end.
+	size  int64
+	stats PoolStats
+}
+
+func newBufferPool(f *os.File, frames int) (*bufferPool, error) {
+	p := &bufferPool{f: f, frames: make([]frame, frames), index: make(map[int64]int, frames)}
+	p.stats.Frames = frames
+	for i := range p.frames {
+		p.frames[i].page = -1
+	}
+	return p, p.resized()
+}
+
+// resized re-reads the file size after it was changed behind the pool.
+func (p *bufferPool) resized() error {
+	st, err := p.f.Stat()
+	if err != nil {
+		return err
+	}
+	p.size = st.Size()
+	return nil
+}
+
+// load returns the index of the frame caching file frame page, loading it and
+// evicting another one if needed. Caller holds p.mu.
+func (p *bufferPool) load(page int64) (int, error) {
+	if i, ok := p.index[page]; ok {
+		p.frames[i].ref = true
+		p.stats.Hits++
+		return i, nil
+	}
+	p.stats.Misses++
+	i, err := p.victim()
+	if err != nil {
+		return -1, err
+	}
+	fr := &p.frames[i]
+	n, err := p.f.ReadAt(fr.data[:], page*poolFrameSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		fr.page = -1
+		return -1, err
+	}
+	// Past the end of the file: what is not written yet reads as zeroes.
+	clear(fr.data[n:])
+	fr.page, fr.ref, fr.dirty = page, true, 0
+	p.index[page] = i
+	return i, nil
+}
+
+// victim picks a frame to reuse, writing it back first if it is dirty.
+func (p *bufferPool) victim() (int, error) {
+	for {
+		i := p.hand
+		p.hand = (p.hand + 1) % len(p.frames)
+		fr := &p.frames[i]
+		if fr.page < 0 {
+			return i, nil
+		}
+		if fr.ref {
+			fr.ref = false
+			continue
+		}
+		if err := p.writeBack(fr); err != nil {
+			return -1, err
+		}
+		delete(p.index, fr.page)
+		fr.page = -1
+		p.stats.Evictions++
+		return i, nil
+	}
+}
+
+// writeBack writes the dirty pages of fr to the file, merging adjacent ones.
+func (p *bufferPool) writeBack(fr *frame) error {
+	for i := 0; i < poolPagesPerFrame; {
+		if fr.dirty&(1<<i) == 0 {
+			i++
+			continue
+		}
+		j := i
+		for j < poolPagesPerFrame && fr.dirty&(1<<j) != 0 {
+			j++
+		}
+		if _, err := p.f.WriteAt(fr.data[i*SlotSize:j*SlotSize], fr.page*poolFrameSize+int64(i)*SlotSize); err != nil {
+			return err
+		}
+		i = j
+	}
+	if fr.dirty != 0 {
+		p.stats.Dirty--
+	}
+	fr.dirty = 0
+	return nil
+}
+
+// readAt fills buf, a whole number of pages at a page-aligned off, from the pool.
+// It reports false, reading nothing, when the range extends past the logical end
+// of the file, so the caller can apply its own rules to that case.
+func (p *bufferPool) readAt(buf []byte, off int64) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if off+int64(len(buf)) > p.size {
+		return false, nil
+	}
+	for len(buf) > 0 {
+		i, err := p.load(off / poolFrameSize)
+		if err != nil {
+			return false, err
+		}
+		n := copy(buf, p.frames[i].data[off%poolFrameSize:])
+		buf, off = buf[n:], off+int64(n)
+	}
+	return true, nil
+}
+
+// writeAt stores page, a whole number of pages at a page-aligned off, in the pool
+// and marks it dirty.
+func (p *bufferPool) writeAt(page []byte, off int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.size = max(p.size, off+int64(len(page)))
+	for len(page) > 0 {
+		i, err := p.load(off / poolFrameSize)
+		if err != nil {
+			return err
+		}
+		fr := &p.frames[i]
+		at := int(off % poolFrameSize)
+		n := copy(fr.data[at:], page)
+		if fr.dirty == 0 {
+			p.stats.Dirty++
+		}
+		for k := at / SlotSize; k < (at+n)/SlotSize; k++ {
+			fr.dirty |= 1 << k
+		}
+		page, off = page[n:], off+int64(n)
+	}
+	return nil
+}
+
+// flush writes every dirty page to the file.
+func (p *bufferPool) flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.frames {
+		if fr := &p.frames[i]; fr.page >= 0 && fr.dirty != 0 {
+			if err := p.writeBack(fr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// drop forgets every cached frame without writing anything back and switches
+// the pool to f.
+func (p *bufferPool) drop(f *os.File) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.frames {
+		p.frames[i] = frame{page: -1}
+	}
+	clear(p.index)
+	p.stats.Dirty = 0
+	p.f = f
+	return p.resized()
+}
+
+func (p *bufferPool) counters() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// applyPooled is applyBatch for a DB with a buffer pool.
+func (db *DB) applyPooled(b *batch) error {
+	if b.reset >= 0 || b.grow > 0 {
+		// The file changes size underneath the pool: settle it first.
+		if err := db.pool.flush(); err != nil {
+			return err
+		}
+		if err := applyBatch(db.f, &batch{reset: b.reset, grow: b.grow}); err != nil {
+			return err
+		}
+		if err := db.pool.drop(db.f); err != nil {
+			return err
+		}
+	}
+	for _, off := range b.order {
+		if err := db.pool.writeAt(b.pages[off], off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
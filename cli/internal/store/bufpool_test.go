@@ -0,0 +1,175 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newTestPool returns a pool of n frames over a file of eight zeroed frames.
+func newTestPool(t *testing.T, n int) *bufferPool {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "pool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+	if err := f.Truncate(8 * poolFrameSize); err != nil {
+		t.Fatal(err)
+	}
+	p, err := newBufferPool(f, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func touch(t *testing.T, p *bufferPool, frames ...int64) {
+	t.Helper()
+	buf := make([]byte, SlotSize)
+	for _, fr := range frames {
+		if ok, err := p.readAt(buf, fr*poolFrameSize); !ok || err != nil {
+			t.Fatalf("readAt frame %d: %v, %v", fr, ok, err)
+		}
+	}
+}
+
+func cachedFrames(p *bufferPool) []int64 {
+	var out []int64
+	for page := range p.index {
+		out = append(out, page)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestBufferPoolClock(t *testing.T) {
+	p := newTestPool(t, 3)
+	touch(t, p, 0, 1, 2)
+	// Every frame is referenced: the hand clears them all and takes the first.
+	touch(t, p, 3)
+	if got := cachedFrames(p); !slices.Equal(got, []int64{1, 2, 3}) {
+		t.Fatalf("cached %v", got)
+	}
+	// Frame 1 is used again and gets a second chance; frame 2 goes instead.
+	touch(t, p, 1, 4)
+	if got := cachedFrames(p); !slices.Equal(got, []int64{1, 3, 4}) {
+		t.Fatalf("cached %v", got)
+	}
+	if st := p.counters(); st.Hits != 1 || st.Misses != 5 || st.Evictions != 2 || st.Frames != 3 {
+		t.Fatalf("counters %+v", st)
+	}
+}
+
+func TestBufferPoolWriteBack(t *testing.T) {
+	p := newTestPool(t, 2)
+	page := bytes.Repeat([]byte{7}, SlotSize)
+	onDisk := func(off int64) []byte {
+		t.Helper()
+		buf := make([]byte, SlotSize)
+		if _, err := p.f.ReadAt(buf, off); err != nil {
+			t.Fatal(err)
+		}
+		return buf
+	}
+	off := int64(poolFrameSize + SlotSize)
+	if err := p.writeAt(page, off); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(onDisk(off), page) || p.counters().Dirty != 1 {
+		t.Fatalf("write went straight to the file or was not marked dirty: %+v", p.counters())
+	}
+	// Evicting the frame writes it back.
+	touch(t, p, 2, 3, 4)
+	if !bytes.Equal(onDisk(off), page) || p.counters().Dirty != 0 {
+		t.Fatalf("eviction lost the dirty page: %+v", p.counters())
+	}
+	// And it reads back through the pool after eviction.
+	buf := make([]byte, SlotSize)
+	if ok, err := p.readAt(buf, off); !ok || err != nil || !bytes.Equal(buf, page) {
+		t.Fatalf("read after eviction: %v, %v", ok, err)
+	}
+
+	if err := p.writeAt(page, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(onDisk(0), page) || p.counters().Dirty != 0 {
+		t.Fatalf("flush left the page dirty: %+v", p.counters())
+	}
+}
+
+func TestBufferPoolDB(t *testing.T) {
+	opts := Options{CachePages: 2}
+	db := createTestDB(t, CreateOptions{Slots: 211, Options: opts})
+	want := make(map[string]string)
+	for i := 0; i < 150; i++ {
+		key := fmt.Sprintf("k%d", i)
+		want[key] = key
+		if err := db.Insert(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, v := range want {
+		var got string
+		if found, err := db.Select(key, &got); err != nil || !found || got != v {
+			t.Fatalf("%s: found=%v value=%q err=%v", key, found, got, err)
+		}
+	}
+	st, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Pool.Frames != 2 || st.Pool.Hits == 0 || st.Pool.Misses == 0 || st.Pool.Evictions == 0 {
+		t.Fatalf("Pool: %+v", st.Pool)
+	}
+	if err := db.Insert("last", "last"); err != nil {
+		t.Fatal(err)
+	}
+	want["last"] = "last"
+	// Stats itself reads every slot through the pool, which would evict the page.
+	if st := db.pool.counters(); st.Dirty == 0 {
+		t.Fatalf("Pool after a write: %+v", st)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if st := db.pool.counters(); st.Dirty != 0 {
+		t.Fatalf("Pool after Flush: %+v", st)
+	}
+	if err := db.Update("last", "again"); err != nil {
+		t.Fatal(err)
+	}
+	want["last"] = "again"
+	if st := db.pool.counters(); st.Dirty == 0 {
+		t.Fatalf("Pool after an update: %+v", st)
+	}
+	if err := db.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if st := db.pool.counters(); st.Dirty != 0 {
+		t.Fatalf("Pool after Checkpoint: %+v", st)
+	}
+
+	// The file alone, read without the pool, holds every write.
+	crash(db)
+	if err := os.Remove(db.path + walSuffix); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(db.path, OpenOptions{Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for key, v := range want {
+		var got string
+		if found, err := db.Select(key, &got); err != nil || !found || got != v {
+			t.Fatalf("%s after reopen: found=%v value=%q err=%v", key, found, got, err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+// ErrCorruptSlot reports a slot whose contents fail verification: a checksum
+// mismatch, an impossible length, a broken overflow chain or an undecodable envelope.
+type ErrCorruptSlot struct {
+	Index int
+	// Hash is the key hash stored in the slot header; it is not verified.
+	Hash   uint32
+	Reason string
+}
+
+func (e *ErrCorruptSlot) Error() string {
+	return fmt.Sprintf("corrupt slot %d (hash %d): %s", e.Index, e.Hash, e.Reason)
+}
+
+// slotChecksum returns the CRC32C of a page header followed by its payload.
+func slotChecksum(header, payload []byte) uint32 {
+	return crc32.Update(crc32.Checksum(header, castagnoli), castagnoli, payload)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyReport is the result of walking the whole file.
+type VerifyReport struct {
+	Slots    int
+	Occupied int
+	// Corrupt lists every slot that failed verification. Problems found on the
+	// overflow free list have Index -1.
+	Corrupt  []ErrCorruptSlot
+	Started  time.Time
+	Finished time.Time
+}
+
+// verifyChunk is how many slots Verify checks per read-lock acquisition, so that
+// a scrub of a large file does not stall writers.
+const verifyChunk = 256
+
+// Verify reads every slot, checks its checksum, decodes occupied slots including
+// their overflow chains, confirms the stored hash matches the key, and walks the
+// overflow free list. Corruption is collected in the report; only I/O errors are returned.
+func (db *DB) Verify() (VerifyReport, error) {
+	r := VerifyReport{Started: time.Now()}
+	for start := 0; ; start += verifyChunk {
+		done, err := db.verifyRange(&r, start, start+verifyChunk)
+		if err != nil {
+			return r, err
+		}
+		if done {
+			break
+		}
+	}
+	db.mu.RLock()
+	err := db.verifyFreeList(&r)
+	db.mu.RUnlock()
+	r.Finished = time.Now()
+	return r, err
+}
+
+// verifyRange checks slots [from, to) and reports whether the end of the table was reached.
+func (db *DB) verifyRange(r *VerifyReport, from, to int) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.f == nil {
+		return true, errors.New("database closed")
+	}
+	for i := from; i < to && i < db.slots; i++ {
+		r.Slots++
+		s, err := db.readSlot(i)
+		var ce *ErrCorruptSlot
+		if errors.As(err, &ce) {
+			r.Corrupt = append(r.Corrupt, *ce)
+			continue
+		}
+		if err != nil {
+			return true, err
+		}
+		if s.state != StateOcc {
+			continue
+		}
+		r.Occupied++
+		env, err := db.slotEnvelope(s)
+		if errors.As(err, &ce) {
+			r.Corrupt = append(r.Corrupt, *ce)
+			continue
+		}
+		if err != nil {
+			return true, err
+		}
+		if db.hashKey(env.Key) != s.hash {
+			r.Corrupt = append(r.Corrupt, ErrCorruptSlot{Index: i, Hash: s.hash, Reason: fmt.Sprintf("stored hash does not match key %q", env.Key)})
+		}
+	}
+	return to >= db.slots, nil
+}
+
+func (db *DB) verifyFreeList(r *VerifyReport) error {
+	page, seen := db.hdr.OverflowFreeHead, uint32(0)
+	for page != 0 {
+		if seen++; seen > db.hdr.OverflowFree {
+			r.Corrupt = append(r.Corrupt, ErrCorruptSlot{Index: -1, Reason: fmt.Sprintf("overflow free list longer than %d", db.hdr.OverflowFree)})
+			return nil
+		}
+		next, _, err := db.readOverflowPage(page)
+		if errors.Is(err, errBadPage) {
+			r.Corrupt = append(r.Corrupt, ErrCorruptSlot{Index: -1, Reason: "overflow free list: " + err.Error()})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		page = next
+	}
+	return nil
+}
+
+// scrub runs Verify every interval until the DB is closed, handing each report to fn.
+func (db *DB) scrub(interval time.Duration, fn func(VerifyReport, error)) {
+	defer db.bg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-t.C:
+			r, err := db.Verify()
+			if fn != nil {
+				fn(r, err)
+			}
+		}
+	}
+}
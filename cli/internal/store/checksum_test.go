@@ -0,0 +1,74 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// flipByte inverts the byte at off in the file at path.
+func flipByte(t *testing.T, path string, off int64) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err := f.WriteAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChecksumDetectsCorruption(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Insert(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx, _, _, err := db.find("b", db.hashKey("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := db.slotOffset(idx) + HeaderSize
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	flipByte(t, db.path, off)
+
+	db, err = Open(db.path, OpenOptions{Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rep, err := db.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Corrupt) != 1 || rep.Corrupt[0].Index != idx || rep.Corrupt[0].Reason != "checksum mismatch" {
+		t.Fatalf("Verify: %+v", rep.Corrupt)
+	}
+	if rep.Slots != 31 || rep.Occupied != 2 {
+		t.Fatalf("Verify: %d slots, %d occupied", rep.Slots, rep.Occupied)
+	}
+	var v string
+	var ce *ErrCorruptSlot
+	if _, err := db.Select("b", &v); !errors.As(err, &ce) || ce.Index != idx {
+		t.Fatalf("Select of the corrupt record: %v", err)
+	}
+	if found, err := db.Select("a", &v); err != nil || !found || v != "a" {
+		t.Fatalf("Select of an intact record: found=%v, %v", found, err)
+	}
+	st, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Corrupt != 1 || st.Occupied != 2 {
+		t.Fatalf("Stats: %d corrupt, %d occupied", st.Corrupt, st.Occupied)
+	}
+}
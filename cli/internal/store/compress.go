@@ -0,0 +1,115 @@
+package store
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// A DB created with CompressDeflate stores each record payload DEFLATE-compressed
+// whenever that makes it smaller, and as is otherwise. A compressed record has
+// flagCompressed set and its payload, inline and overflow alike, is
+//
+//	len(uint32, uncompressed length) | deflate stream
+//
+// Everything above payload and writeRecord sees the uncompressed envelope. Slots
+// move between positions as they are, flag included, so only writing a record
+// compresses it.
+const flagCompressed = 1 << 1
+
+// Compression identifies how record payloads are compressed in a DB file.
+type Compression uint8
+
+const (
+	CompressNone    Compression = 0
+	CompressDeflate Compression = 1
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressNone:
+		return "none"
+	case CompressDeflate:
+		return "deflate"
+	}
+	return fmt.Sprintf("compression(%d)", uint8(c))
+}
+
+var deflaters = sync.Pool{New: func() any {
+	w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+	return w
+}}
+
+// compressPayload returns the compressed form of payload, or ok=false when it
+// would not be smaller.
+func compressPayload(payload []byte) (out []byte, ok bool) {
+	var buf bytes.Buffer
+	buf.Grow(len(payload))
+	buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(payload))))
+	w := deflaters.Get().(*flate.Writer)
+	defer deflaters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(payload); err != nil {
+		return payload, false
+	}
+	if err := w.Close(); err != nil {
+		return payload, false
+	}
+	if buf.Len() >= len(payload) {
+		return payload, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompressPayload reverses compressPayload.
+func decompressPayload(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, errors.New("short compressed payload")
+	}
+	n := int(binary.LittleEndian.Uint32(data[0:4]))
+	if n > MaxPayloadSize {
+		return nil, fmt.Errorf("uncompressed length %d too large", n)
+	}
+	r := flate.NewReader(bytes.NewReader(data[4:]))
+	defer r.Close()
+	out := make([]byte, n)
+	if _, err := io.ReadFull(r, out); err != nil {
+		return nil, err
+	}
+	if m, _ := r.Read(make([]byte, 1)); m != 0 {
+		return nil, fmt.Errorf("uncompressed length exceeds %d", n)
+	}
+	return out, nil
+}
+
+// inflate returns the uncompressed payload of s given its stored payload data.
+func inflate(s slot, data []byte) ([]byte, error) {
+	if s.flags&flagCompressed == 0 {
+		return data, nil
+	}
+	out, err := decompressPayload(data)
+	if err != nil {
+		return nil, &ErrCorruptSlot{Index: s.index, Hash: s.hash, Reason: "bad compressed payload: " + err.Error()}
+	}
+	return out, nil
+}
+
+// payloadSizes returns the size of the payload of s as written and as stored,
+// read from the slot alone without following its overflow chain.
+func payloadSizes(s slot) (raw, stored int) {
+	stored = len(s.data)
+	data := s.data
+	if s.flags&flagOverflow != 0 && len(s.data) >= ovfPtrSize {
+		stored = int(binary.LittleEndian.Uint32(s.data[4:8]))
+		data = s.data[ovfPtrSize:]
+	}
+	raw = stored
+	if s.flags&flagCompressed != 0 && len(data) >= 4 {
+		raw = int(binary.LittleEndian.Uint32(data[0:4]))
+	}
+	return raw, stored
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"bytes"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// slotFlags returns the flags of the slot holding key.
+func slotFlags(t *testing.T, db *DB, key string) byte {
+	t.Helper()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	_, s, env, err := db.find(key, db.hashKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env == nil {
+		t.Fatalf("%s not found", key)
+	}
+	return s.flags
+}
+
+func TestCompression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.bin")
+	db, err := Create(path, CreateOptions{Slots: 31, Compression: CompressDeflate, Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Far larger than a slot before compression.
+	big := strings.Repeat("compressible ", 200)
+	values := map[string]string{"big": big, "small": "x"}
+	for key, v := range values {
+		if err := db.Insert(key, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if slotFlags(t, db, "big")&flagCompressed == 0 {
+		t.Fatal("compressible record stored raw")
+	}
+	// Deflate framing would make a payload this short larger: it is kept raw.
+	if slotFlags(t, db, "small")&flagCompressed != 0 {
+		t.Fatal("short record stored compressed")
+	}
+	st, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Compressed != 1 || st.StoredBytes >= st.PayloadBytes || st.OverflowPages != 0 {
+		t.Fatalf("Stats: %d compressed, %d of %d bytes stored, %d overflow pages",
+			st.Compressed, st.StoredBytes, st.PayloadBytes, st.OverflowPages)
+	}
+
+	// Rewriting a record compresses it afresh.
+	if err := db.Update("big", "y"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update("small", big); err != nil {
+		t.Fatal(err)
+	}
+	values["big"], values["small"] = "y", big
+	if slotFlags(t, db, "big")&flagCompressed != 0 || slotFlags(t, db, "small")&flagCompressed == 0 {
+		t.Fatal("updates kept the old compression flags")
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(path, OpenOptions{Options: Options{ReapInterval: -1}}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for key, want := range values {
+		var v string
+		if found, err := db.Select(key, &v); err != nil || !found || v != want {
+			t.Fatalf("%s: found=%v, %d bytes, err=%v", key, found, len(v), err)
+		}
+	}
+	if rep, err := db.Verify(); err != nil || len(rep.Corrupt) != 0 {
+		t.Fatalf("Verify: %+v, %v", rep, err)
+	}
+}
+
+func TestCompressPayloadFallsBackToRaw(t *testing.T) {
+	noise := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(noise)
+	if out, ok := compressPayload(noise); ok || !bytes.Equal(out, noise) {
+		t.Fatalf("random bytes compressed to %d of %d bytes", len(out), len(noise))
+	}
+	text := bytes.Repeat([]byte("abc"), 300)
+	out, ok := compressPayload(text)
+	if !ok || len(out) >= len(text) {
+		t.Fatalf("repetitive text: ok=%v, %d of %d bytes", ok, len(out), len(text))
+	}
+	back, err := decompressPayload(out)
+	if err != nil || !bytes.Equal(back, text) {
+		t.Fatalf("round trip: %d bytes, %v", len(back), err)
+	}
+	// A length prefix that does not match the stream is rejected.
+	bad := bytes.Clone(out)
+	bad[0]--
+	if _, err := decompressPayload(bad); err == nil {
+		t.Fatal("decompressPayload accepted a wrong length")
+	}
+}
@@ -0,0 +1,168 @@
+package store
+
+import "iter"
+
+// Cuckoo hashing splits the slots into two equal tables and a small stash at the
+// end of the slot area. A key lives either at its slot in the first table or at
+// its slot in the second one, so a lookup reads at most two slots plus the stash.
+// An insert that finds both taken evicts the resident of the first and moves it
+// to its other slot, repeating up to cuckooMaxKicks times; the entry left over
+// after that goes to the stash, and a full stash fails with ErrTableFull, which
+// makes Insert grow and rehash the table.
+//
+// Both positions are derived from the 32-bit hash kept in the slot header, so
+// evictions and rehashes never have to decode a record to find out where it may go.
+// The price is that they are not independent: keys whose 32-bit hashes collide
+// share both candidate slots, whatever the table size. Two such keys fit there and
+// the stash takes up to cuckooMaxStash more; past that no amount of growing helps
+// and Insert fails with ErrTableFull. That many keys never collide by chance, but
+// they can be crafted against the unseeded FNV-1a; a seeded hash prevents that.
+const (
+	cuckooMaxKicks = 32
+	cuckooMaxStash = 8
+)
+
+type cuckooProber struct {
+	table
+	half int // slots per table; the stash is [2*half, slots)
+}
+
+func newCuckooProber(t table) cuckooProber {
+	stash := min(cuckooMaxStash, max(1, t.slots/16))
+	return cuckooProber{table: t, half: (t.slots - stash) / 2}
+}
+
+func (p cuckooProber) first(hk uint32) int {
+	return int(hk % uint32(p.half))
+}
+
+func (p cuckooProber) second(hk uint32) int {
+	// fmix32 from MurmurHash3 spreads hk again, so that keys sharing a first
+	// position rarely share the second; keys sharing hk always share both.
+	h := hk ^ 0x9e3779b9
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return p.half + int(h%uint32(p.half))
+}
+
+// other returns the position of hk in the table it does not occupy at idx.
+func (p cuckooProber) other(hk uint32, idx int) int {
+	if idx == p.first(hk) {
+		return p.second(hk)
+	}
+	return p.first(hk)
+}
+
+// Probe yields the two candidate slots of hk followed by the stash.
+func (p cuckooProber) Probe(hk uint32) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		if !yield(p.first(hk)) || !yield(p.second(hk)) {
+			return
+		}
+		for idx := 2 * p.half; idx < p.slots; idx++ {
+			if !yield(idx) {
+				return
+			}
+		}
+	}
+}
+
+func (p cuckooProber) Distance(hk uint32, idx int) int {
+	switch {
+	case idx == p.first(hk):
+		return 0
+	case idx == p.second(hk):
+		return 1
+	}
+	return 2 + idx - 2*p.half
+}
+
+// place is insertCuckoo on the in-memory layout of rehashInto.
+func (p cuckooProber) place(pos []int, hashes []uint32, e int) bool {
+	h := hashes[e]
+	for _, idx := range [2]int{p.first(h), p.second(h)} {
+		if pos[idx] == 0 {
+			pos[idx] = e + 1
+			return true
+		}
+	}
+	idx := p.first(h)
+	for kick := 0; kick < cuckooMaxKicks; kick++ {
+		o := pos[idx] - 1
+		pos[idx] = e + 1
+		e = o
+		idx = p.other(hashes[e], idx)
+		if pos[idx] == 0 {
+			pos[idx] = e + 1
+			return true
+		}
+	}
+	for idx := 2 * p.half; idx < p.slots; idx++ {
+		if pos[idx] == 0 {
+			pos[idx] = e + 1
+			return true
+		}
+	}
+	return false
+}
+
+// insertCuckoo places payload at one of the two slots of hk, evicting residents
+// along the way, or in the stash. Evicted records keep their version and overflow
+// chain. Caller runs it inside db.write, so a failed chain of evictions is
+// discarded with the rest of the batch.
+func (db *DB) insertCuckoo(p cuckooProber, key string, hk uint32, expires int64, payload []byte) error {
+	_, _, env, err := db.find(key, hk)
+	if err != nil {
+		return err
+	}
+	if env != nil {
+		return ErrKeyExists
+	}
+	for _, idx := range [2]int{p.first(hk), p.second(hk)} {
+		s, err := db.readSlot(idx)
+		if err != nil {
+			return err
+		}
+		if s.state != StateOcc {
+			return db.occupy(idx, s.state, hk, expires, payload)
+		}
+	}
+
+	// carry is the record evicted last; the new one goes into the first table.
+	idx := p.first(hk)
+	carry, err := db.readSlot(idx)
+	if err != nil {
+		return err
+	}
+	if err := db.writeRecord(idx, hk, db.hdr.LastVersion+1, expires, payload); err != nil {
+		return err
+	}
+	db.used++
+	for kick := 0; kick < cuckooMaxKicks; kick++ {
+		idx = p.other(carry.hash, idx)
+		s, err := db.readSlot(idx)
+		if err != nil {
+			return err
+		}
+		if err := db.writeSlot(idx, carry); err != nil {
+			return err
+		}
+		if s.state != StateOcc {
+			return nil
+		}
+		carry = s
+	}
+	for idx := 2 * p.half; idx < p.slots; idx++ {
+		s, err := db.readSlot(idx)
+		if err != nil {
+			return err
+		}
+		if s.state != StateOcc {
+			return db.writeSlot(idx, carry)
+		}
+	}
+	return ErrTableFull
+}
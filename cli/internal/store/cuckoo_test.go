@@ -0,0 +1,104 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// keysSharingPositions returns n keys with the same two cuckoo positions in db.
+func keysSharingPositions(db *DB, n int) []string {
+	p := db.probe.(cuckooProber)
+	var keys []string
+	var first, second int
+	for i := 0; len(keys) < n; i++ {
+		key := fmt.Sprintf("c%d", i)
+		hk := db.hashKey(key)
+		if len(keys) > 0 && (p.first(hk) != first || p.second(hk) != second) {
+			continue
+		}
+		first, second = p.first(hk), p.second(hk)
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestCuckooPlacement(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 211, Probe: ProbeCuckoo})
+	var keys []string
+	for i := 0; i < 300; i++ {
+		keys = append(keys, fmt.Sprintf("k%d", i))
+	}
+	insertAll(t, db, keys...)
+	for i := 0; i < len(keys); i += 2 {
+		if _, err := db.Delete(keys[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := db.probe.(cuckooProber)
+	st, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every record sits at one of its two positions or in the stash, and deletes
+	// leave no tombstones behind.
+	if limit := 2 + p.slots - 2*p.half; st.MaxProbe > limit || st.Deleted != 0 {
+		t.Fatalf("MaxProbe %d over %d, %d tombstones", st.MaxProbe, limit, st.Deleted)
+	}
+	for i, key := range keys {
+		var v string
+		if found, err := db.Select(key, &v); err != nil || found != (i%2 == 1) {
+			t.Fatalf("%s: found=%v, %v", key, found, err)
+		}
+	}
+}
+
+func TestCuckooStashFills(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 7, Probe: ProbeCuckoo, Options: Options{MaxLoadFactor: -1}})
+	p := db.probe.(cuckooProber)
+	if stash := p.slots - 2*p.half; stash != 1 {
+		t.Fatalf("stash of %d slots", stash)
+	}
+	keys := keysSharingPositions(db, 4)
+	// Two keys take the positions and the third goes to the stash.
+	insertAll(t, db, keys[:3]...)
+	if d, err := db.SlotDetail(2 * p.half); err != nil || d.State != StateOcc {
+		t.Fatalf("stash slot: %+v, %v", d, err)
+	}
+	if err := db.Insert(keys[3], keys[3]); !errors.Is(err, ErrTableFull) {
+		t.Fatalf("Insert with the stash full: %v", err)
+	}
+	// The failed insert evicted nothing for good.
+	for _, key := range keys[:3] {
+		var v string
+		if found, err := db.Select(key, &v); err != nil || !found || v != key {
+			t.Fatalf("%s: found=%v value=%q err=%v", key, found, v, err)
+		}
+	}
+	// Deleting the stashed record makes room again.
+	d, err := db.SlotDetail(2 * p.half)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Delete(d.Key); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert(keys[3], keys[3]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCuckooGrowsWhenStashFull(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 7, Probe: ProbeCuckoo})
+	keys := keysSharingPositions(db, 4)
+	insertAll(t, db, keys...)
+	if h := db.Header(); h.Slots <= 7 {
+		t.Fatalf("table kept %d slots", h.Slots)
+	}
+	for _, key := range keys {
+		var v string
+		if found, err := db.Select(key, &v); err != nil || !found || v != key {
+			t.Fatalf("%s: found=%v value=%q err=%v", key, found, v, err)
+		}
+	}
+}
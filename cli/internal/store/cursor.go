@@ -0,0 +1,157 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+)
+
+var (
+	ErrBadCursor   = errors.New("malformed cursor token")
+	ErrStaleCursor = errors.New("cursor invalidated by a table rebuild")
+)
+
+// ScanOption configures a Cursor.
+type ScanOption func(*Cursor)
+
+// WithPrefix keeps only keys starting with prefix.
+func WithPrefix(prefix string) ScanOption {
+	return func(c *Cursor) { c.prefix = prefix }
+}
+
+// WithType keeps only records whose stored type name equals typeName
+// (e.g. "models.Client" or "json.RawMessage").
+func WithType(typeName string) ScanOption {
+	return func(c *Cursor) { c.typeName = typeName }
+}
+
+// After resumes iteration after the position captured by a previous Cursor.Token.
+func After(token string) ScanOption {
+	return func(c *Cursor) { c.token = token }
+}
+
+// Cursor iterates over live records in slot order. It reads the table in chunks
+// under the read lock and yields outside of it, so the loop body may call back
+// into the DB. The iteration is weakly consistent: records written during it
+// may or may not be seen, and a backward-shift delete, a cuckoo eviction or a
+// linear hashing split can move a record the cursor has not reached yet behind
+// it. A rebuild of the table (resize or Vacuum) ends it with ErrStaleCursor.
+type Cursor struct {
+	db       *DB
+	prefix   string
+	typeName string
+	token    string
+
+	gen   uint32
+	next  int
+	slots int
+	err   error
+}
+
+// cursorChunk is how many slots a Cursor reads per read-lock acquisition.
+const cursorChunk = 128
+
+// Scan returns a Cursor over live records.
+func (db *DB) Scan(opts ...ScanOption) *Cursor {
+	c := &Cursor{db: db}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// All iterates over (key, data) of every live record matching opts.
+// Use Scan instead to get at the resume token or the iteration error.
+func (db *DB) All(opts ...ScanOption) iter.Seq2[string, json.RawMessage] {
+	return db.Scan(opts...).All()
+}
+
+type cursorEntry struct {
+	key  string
+	data json.RawMessage
+	next int
+}
+
+// All iterates over (key, data) pairs. Stopping early is fine; Token then resumes
+// after the last pair yielded.
+func (c *Cursor) All() iter.Seq2[string, json.RawMessage] {
+	return func(yield func(string, json.RawMessage) bool) {
+		if c.err != nil {
+			return
+		}
+		if c.token != "" {
+			if _, err := fmt.Sscanf(c.token, "%x.%x", &c.gen, &c.next); err != nil {
+				c.err = fmt.Errorf("%w: %q", ErrBadCursor, c.token)
+				return
+			}
+			c.token = ""
+		} else {
+			c.db.mu.RLock()
+			c.gen = c.db.hdr.generation()
+			c.db.mu.RUnlock()
+		}
+		for {
+			batch, end, err := c.read()
+			if err != nil {
+				c.err = err
+				return
+			}
+			for _, e := range batch {
+				c.next = e.next
+				if !yield(e.key, e.data) {
+					return
+				}
+			}
+			if c.next = end; end >= c.slots {
+				return
+			}
+		}
+	}
+}
+
+// read collects the matching records of the next chunk of slots and returns
+// the slot index the following chunk starts at.
+func (c *Cursor) read() ([]cursorEntry, int, error) {
+	db := c.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.f == nil {
+		return nil, 0, errors.New("database closed")
+	}
+	if db.hdr.generation() != c.gen {
+		return nil, 0, ErrStaleCursor
+	}
+	c.slots = db.slots
+	var out []cursorEntry
+	end := min(c.next+cursorChunk, db.slots)
+	for i := c.next; i < end; i++ {
+		s, err := db.readSlot(i)
+		if err != nil {
+			return nil, 0, err
+		}
+		if s.state != StateOcc || s.expired() {
+			continue
+		}
+		env, err := db.slotEnvelope(s)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !strings.HasPrefix(env.Key, c.prefix) || (c.typeName != "" && env.Type != c.typeName) {
+			continue
+		}
+		out = append(out, cursorEntry{key: env.Key, data: env.Data, next: i + 1})
+	}
+	return out, end, nil
+}
+
+// Token returns an opaque position to pass to After to resume the iteration.
+func (c *Cursor) Token() string {
+	return fmt.Sprintf("%x.%x", c.gen, c.next)
+}
+
+// Err returns the error that ended the iteration, if any.
+func (c *Cursor) Err() error {
+	return c.err
+}
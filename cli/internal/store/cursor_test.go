@@ -0,0 +1,105 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// scanKeys returns the keys c yields, sorted, and fails if it yields one twice
+// or ends with an error.
+func scanKeys(t *testing.T, c *Cursor) []string {
+	t.Helper()
+	var keys []string
+	for key := range c.All() {
+		keys = append(keys, key)
+	}
+	if err := c.Err(); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(keys)
+	if n := len(keys); len(slices.Compact(keys)) != n {
+		t.Fatal("the cursor yielded a key twice")
+	}
+	return keys
+}
+
+func TestCursorPrefixAndResume(t *testing.T) {
+	// More slots than a cursor reads per chunk.
+	db := createTestDB(t, CreateOptions{Slots: 521})
+	var users []string
+	for i := 0; i < 200; i++ {
+		users = append(users, fmt.Sprintf("user:%03d", i))
+		if err := db.Insert(users[i], i); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Insert(fmt.Sprintf("admin:%03d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := scanKeys(t, db.Scan(WithPrefix("user:"))); !slices.Equal(got, users) {
+		t.Fatalf("prefix scan returned %d keys, want %d", len(got), len(users))
+	}
+
+	// Stop part way and resume from the token.
+	c := db.Scan(WithPrefix("user:"))
+	var first []string
+	for key := range c.All() {
+		if first = append(first, key); len(first) == 70 {
+			break
+		}
+	}
+	rest := scanKeys(t, db.Scan(WithPrefix("user:"), After(c.Token())))
+	got := append(first, rest...)
+	slices.Sort(got)
+	if !slices.Equal(got, users) {
+		t.Fatalf("resumed scan covered %d keys, want %d", len(got), len(users))
+	}
+
+	bad := db.Scan(After("nonsense"))
+	for range bad.All() {
+		t.Fatal("a bad token yielded a record")
+	}
+	if !errors.Is(bad.Err(), ErrBadCursor) {
+		t.Fatalf("bad token: %v", bad.Err())
+	}
+}
+
+func TestCursorStaleAfterRebuild(t *testing.T) {
+	rebuilds := map[string]func(db *DB) error{
+		"resize": func(db *DB) error {
+			db.wmu.Lock()
+			defer db.wmu.Unlock()
+			return db.resize(0)
+		},
+		"vacuum": func(db *DB) error {
+			_, err := db.Vacuum()
+			return err
+		},
+	}
+	for name, rebuild := range rebuilds {
+		t.Run(name, func(t *testing.T) {
+			db := createTestDB(t, CreateOptions{Slots: 31})
+			for i := 0; i < 10; i++ {
+				if err := db.Insert(fmt.Sprint(i), i); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c := db.Scan()
+			for range c.All() {
+				break
+			}
+			if err := rebuild(db); err != nil {
+				t.Fatal(err)
+			}
+			resumed := db.Scan(After(c.Token()))
+			for range resumed.All() {
+				t.Fatal("a stale cursor yielded a record")
+			}
+			if !errors.Is(resumed.Err(), ErrStaleCursor) {
+				t.Fatalf("resume after %s: %v", name, resumed.Err())
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// A write is durable once its batch is in the WAL on stable storage: replay on
+// Open redoes it even if the DB file never saw it. Appending the batch is not
+// enough, since the operating system may hold it in its cache for a while, so
+// Options.Durability decides when the WAL is fsynced:
+//
+//   - DurabilityNone never does so between checkpoints. A crash of the process
+//     loses nothing, a crash of the machine can lose the latest writes.
+//   - DurabilitySync fsyncs after every batch, before the write returns.
+//   - DurabilityGroup lets concurrent writers share one fsync. A writer that
+//     finds no sync in progress leads a round: when no other writer is queued
+//     behind it, it fsyncs at once, as DurabilitySync would; otherwise it waits
+//     until those writers have committed, but at most GroupCommitWindow, and
+//     then fsyncs for all of them. Writers that commit while an fsync runs are
+//     covered by the next one. Writers wait for the fsync only after releasing
+//     db.wmu, which is what lets others commit behind.
+//
+// Group commit trades latency for throughput: a lone writer pays one fsync as
+// with DurabilitySync, while under contention each write may wait up to the
+// window plus two fsyncs, and in exchange many writes cost a single fsync.
+//
+// The background reaper only removes expired records and does not wait; its
+// deletes reach stable storage with the next sync or checkpoint.
+type Durability uint8
+
+const (
+	DurabilityNone Durability = iota
+	DurabilitySync
+	DurabilityGroup
+)
+
+// DefaultGroupCommitWindow is how long the leader of a group commit waits at most
+// for the writers queued behind it before it syncs.
+const DefaultGroupCommitWindow = 200 * time.Microsecond
+
+func (d Durability) String() string {
+	switch d {
+	case DurabilityNone:
+		return "none"
+	case DurabilitySync:
+		return "sync"
+	case DurabilityGroup:
+		return "group"
+	}
+	return fmt.Sprintf("durability(%d)", uint8(d))
+}
+
+// groupCommit tracks which part of the WAL is known to be on stable storage.
+// WAL positions are counted in bytes appended since Open (db.lsn), so they keep
+// growing across checkpoints.
+type groupCommit struct {
+	mu     sync.Mutex
+	synced uint64
+	// round is closed when the sync in progress finishes; nil when there is none.
+	round chan struct{}
+	// queued counts writers between lockWriter and unlockWriter. drained, when
+	// not nil, is closed once queued drops to zero for the leader waiting on it.
+	queued  int
+	drained chan struct{}
+}
+
+// markSynced records that the WAL is on stable storage up to lsn.
+func (g *groupCommit) markSynced(lsn uint64) {
+	g.mu.Lock()
+	g.synced = max(g.synced, lsn)
+	g.mu.Unlock()
+}
+
+// dequeue counts a writer out of the queue, waking a leader waiting for it to drain.
+func (g *groupCommit) dequeue() {
+	g.mu.Lock()
+	g.queued--
+	if g.queued == 0 && g.drained != nil {
+		close(g.drained)
+		g.drained = nil
+	}
+	g.mu.Unlock()
+}
+
+// lockWriter takes db.wmu at the start of a mutation. With DurabilityGroup it
+// first counts the writer as queued, so that a group commit leader waits for it.
+func (db *DB) lockWriter() {
+	if db.opts.Durability == DurabilityGroup {
+		db.group.mu.Lock()
+		db.group.queued++
+		db.group.mu.Unlock()
+	}
+	db.wmu.Lock()
+}
+
+// unlockWriter releases db.wmu at the end of a mutation and, with DurabilityGroup,
+// then waits until what the mutation committed is on stable storage. Mutations
+// defer it with a pointer to their error, which it sets if the sync fails.
+func (db *DB) unlockWriter(errp *error) {
+	// Only writers holding wmu advance lsn.
+	lsn := db.lsn
+	db.wmu.Unlock()
+	if db.opts.Durability != DurabilityGroup {
+		return
+	}
+	db.group.dequeue()
+	if *errp != nil {
+		return
+	}
+	*errp = db.syncTo(lsn)
+}
+
+// syncTo returns once the WAL is on stable storage up to lsn, leading a group
+// commit round if none is in progress.
+func (db *DB) syncTo(lsn uint64) error {
+	g := &db.group
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.synced < lsn {
+		if round := g.round; round != nil {
+			g.mu.Unlock()
+			<-round
+			g.mu.Lock()
+			continue
+		}
+		round := make(chan struct{})
+		g.round = round
+		var drained chan struct{}
+		if g.queued > 0 && db.opts.GroupCommitWindow > 0 {
+			drained = make(chan struct{})
+			g.drained = drained
+		}
+		g.mu.Unlock()
+		if drained != nil {
+			t := time.NewTimer(db.opts.GroupCommitWindow)
+			select {
+			case <-drained:
+			case <-t.C:
+			}
+			t.Stop()
+		}
+		upTo, err := db.syncWAL()
+		g.mu.Lock()
+		g.round, g.drained = nil, nil
+		close(round)
+		if err != nil {
+			if g.synced >= lsn {
+				// A checkpoint, say by Close, got there first.
+				return nil
+			}
+			return err
+		}
+		g.synced = max(g.synced, upTo)
+	}
+	return nil
+}
+
+// syncWAL fsyncs the WAL and returns the position it is synced up to. It holds
+// db.mu only to read that position, so writers can keep appending meanwhile.
+func (db *DB) syncWAL() (uint64, error) {
+	db.mu.RLock()
+	wal, lsn := db.wal, db.lsn
+	closed := db.f == nil
+	db.mu.RUnlock()
+	if closed {
+		return 0, os.ErrClosed
+	}
+	if err := wal.Sync(); err != nil {
+		return 0, fmt.Errorf("wal: %w", err)
+	}
+	return lsn, nil
+}
+
+// Flush makes every write committed so far durable, whatever Options.Durability
+// says: it writes back the pages held dirty in the buffer pool and fsyncs the WAL.
+// Unlike Checkpoint it leaves the WAL in place. A read-only DB has nothing to flush.
+func (db *DB) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.f == nil {
+		return os.ErrClosed
+	}
+	if db.readOnly {
+		return nil
+	}
+	if db.pool != nil {
+		if err := db.pool.flush(); err != nil {
+			return err
+		}
+	}
+	if err := db.wal.Sync(); err != nil {
+		return fmt.Errorf("wal: %w", err)
+	}
+	db.group.markSynced(db.lsn)
+	return nil
+}
+
+// syncDir flushes the directory holding path, so that a file just renamed to
+// path is still there after a crash. Windows cannot sync a directory and makes
+// renames durable on its own.
+func syncDir(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,256 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Every record payload is an envelope holding the original key, the name of the
+// Go type the value was stored as and the JSON encoding of the value. Files of
+// format version 6 and later use a binary envelope:
+//
+//	envBinary | flags(uint8) | keyLen(uvarint) | key | type | data
+//
+// where type is the uvarint id the type was registered under with RegisterType,
+// or, with envTypeName set in flags, nameLen(uvarint) | name for a type that is
+// not registered. data is the rest of the payload, the JSON value as is.
+//
+// A program need not register every type that files it reads were written with:
+// a record with an id it does not know reads as type "type#<id>", and writing
+// the record back keeps the id.
+//
+// Version 5 files stored the envelope itself as JSON, {"key":..,"type":..,"data":..}.
+// Open rewrites their records in the binary form and then bumps the version;
+// until it is done, and in a version 5 file opened read-only, both forms are
+// read. They are told apart by the first byte, as JSON never starts with envBinary.
+const (
+	envBinary   = 0x01
+	envTypeName = 1 << 0
+)
+
+// jsonEnvelopeVersion is the last format version with JSON envelopes.
+const jsonEnvelopeVersion = 5
+
+// migrateChunk is how many slots one batch of the envelope migration covers.
+const migrateChunk = 256
+
+// envelope stores the original key and JSON payload of the value.
+type envelope struct {
+	Key  string          `json:"key"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+var types = struct {
+	sync.RWMutex
+	byID   map[uint16]string
+	byName map[string]uint16
+}{byID: make(map[uint16]string), byName: make(map[string]uint16)}
+
+func init() {
+	registerType(1, json.RawMessage(nil))
+	registerType(2, map[string]any(nil))
+}
+
+// firstUserTypeID is the lowest id RegisterType accepts; those below it are the
+// store's own.
+const firstUserTypeID = 16
+
+// RegisterType makes records stored as values of the type of v carry id in their
+// envelope instead of the type name. Ids are stored in DB files, so a type must
+// keep its id for as long as files written with it are around, and every program
+// reading those files must register it too. Ids below 16 are reserved for the
+// store. RegisterType panics on a reserved id and if either the id or the type is
+// already registered differently.
+func RegisterType(id uint16, v any) {
+	if id < firstUserTypeID {
+		panic(fmt.Sprintf("store: type id %d is reserved", id))
+	}
+	registerType(id, v)
+}
+
+func registerType(id uint16, v any) {
+	name := typeName(v)
+	types.Lock()
+	defer types.Unlock()
+	if n, ok := types.byID[id]; ok && n != name {
+		panic(fmt.Sprintf("store: type id %d registered for both %s and %s", id, n, name))
+	}
+	if i, ok := types.byName[name]; ok && i != id {
+		panic(fmt.Sprintf("store: type %s registered as both %d and %d", name, i, id))
+	}
+	types.byID[id] = name
+	types.byName[name] = id
+}
+
+// unknownTypePrefix starts the type name of records whose type id is not registered.
+const unknownTypePrefix = "type#"
+
+// typeID returns the id records of the type named name are stored with.
+func typeID(name string) (uint16, bool) {
+	types.RLock()
+	id, ok := types.byName[name]
+	types.RUnlock()
+	if ok {
+		return id, true
+	}
+	if rest, found := strings.CutPrefix(name, unknownTypePrefix); found {
+		if n, err := strconv.ParseUint(rest, 10, 16); err == nil && n > 0 && rest == strconv.FormatUint(n, 10) {
+			return uint16(n), true
+		}
+	}
+	return 0, false
+}
+
+// typeName returns the friendly name of the type of v, looking through a pointer.
+func typeName(v any) string {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.String()
+}
+
+func marshalEnvelope(key string, v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return encodeEnvelope(&envelope{Key: key, Type: typeName(v), Data: data}), nil
+}
+
+// encodeEnvelope returns the binary form of env.
+func encodeEnvelope(env *envelope) []byte {
+	buf := make([]byte, 0, 2+2*binary.MaxVarintLen16+len(env.Key)+len(env.Data))
+	id, ok := typeID(env.Type)
+	var flags byte
+	if !ok {
+		flags |= envTypeName
+	}
+	buf = append(buf, envBinary, flags)
+	buf = binary.AppendUvarint(buf, uint64(len(env.Key)))
+	buf = append(buf, env.Key...)
+	if ok {
+		buf = binary.AppendUvarint(buf, uint64(id))
+	} else {
+		buf = binary.AppendUvarint(buf, uint64(len(env.Type)))
+		buf = append(buf, env.Type...)
+	}
+	return append(buf, env.Data...)
+}
+
+// decodeEnvelope decodes an envelope in either form. The result does not share
+// memory with b.
+func decodeEnvelope(b []byte) (*envelope, error) {
+	if len(b) == 0 || b[0] != envBinary {
+		var env envelope
+		if err := json.Unmarshal(b, &env); err != nil {
+			return nil, err
+		}
+		return &env, nil
+	}
+	if len(b) < 2 {
+		return nil, errors.New("short envelope")
+	}
+	flags, rest := b[1], b[2:]
+	if flags&^envTypeName != 0 {
+		return nil, fmt.Errorf("unknown envelope flags %#x", flags)
+	}
+	key, rest, err := cutField(rest)
+	if err != nil {
+		return nil, fmt.Errorf("key: %w", err)
+	}
+	env := &envelope{Key: string(key)}
+	if flags&envTypeName != 0 {
+		name, r, err := cutField(rest)
+		if err != nil {
+			return nil, fmt.Errorf("type: %w", err)
+		}
+		env.Type, rest = string(name), r
+	} else {
+		id, n := binary.Uvarint(rest)
+		if n <= 0 || id == 0 || id > 1<<16-1 {
+			return nil, errors.New("type: bad id")
+		}
+		types.RLock()
+		name, ok := types.byID[uint16(id)]
+		types.RUnlock()
+		if !ok {
+			name = unknownTypePrefix + strconv.FormatUint(id, 10)
+		}
+		env.Type, rest = name, rest[n:]
+	}
+	env.Data = bytes.Clone(rest)
+	return env, nil
+}
+
+// cutField splits a uvarint length-prefixed field off the front of b.
+func cutField(b []byte) (field, rest []byte, err error) {
+	n, k := binary.Uvarint(b)
+	if k <= 0 || n > uint64(len(b)-k) {
+		return nil, nil, errors.New("bad length")
+	}
+	return b[k : k+int(n)], b[k+int(n):], nil
+}
+
+// migrateEnvelopes rewrites the records of a file with JSON envelopes in the
+// binary form and then bumps the file to FormatVersion. Records keep their slot,
+// version and expiry, so the key index and secondary indexes stay valid. It
+// commits a chunk of slots at a time: after a crash half way the file is still
+// at the old version, and the next Open picks the migration up again, skipping
+// what is done. A corrupt slot is left as it is for Verify to report.
+// Only newDB calls it.
+func (db *DB) migrateEnvelopes() error {
+	for start := 0; start < db.slots; start += migrateChunk {
+		err := db.write(func() error {
+			for i := start; i < min(start+migrateChunk, db.slots); i++ {
+				s, err := db.readSlot(i)
+				if err != nil && !errors.As(err, new(*ErrCorruptSlot)) {
+					return err
+				}
+				if err != nil || s.state != StateOcc {
+					continue
+				}
+				payload, err := db.payload(s)
+				if errors.As(err, new(*ErrCorruptSlot)) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if len(payload) > 0 && payload[0] == envBinary {
+					continue
+				}
+				env, err := decodeEnvelope(payload)
+				if err != nil {
+					continue
+				}
+				if err := db.freeOverflow(s); err != nil {
+					return err
+				}
+				if err := db.writeRecord(i, s.hash, s.version, s.expires, encodeEnvelope(env)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("migrate envelopes: %w", err)
+		}
+	}
+	err := db.write(func() error {
+		db.hdr.Version = FormatVersion
+		return db.writeHeader()
+	})
+	if err != nil {
+		return fmt.Errorf("migrate envelopes: %w", err)
+	}
+	return nil
+}
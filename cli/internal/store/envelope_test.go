@@ -0,0 +1,304 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type migrateRec struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// v5Record is what writeV5 stored under a key.
+type v5Record struct {
+	value   any
+	version uint32
+	ttl     bool
+}
+
+// writeV5 creates a DB file at path in format version 5: records with JSON
+// envelopes, exactly as that version wrote them. It covers a registered type,
+// unregistered ones, overflow chains, updated versions and expiry times, over
+// enough slots that migrating takes several batches.
+func writeV5(t *testing.T, path string) map[string]v5Record {
+	t.Helper()
+	RegisterType(16, migrateRec{})
+	db, err := Create(path, CreateOptions{Slots: 1031, Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[string]v5Record)
+	for i := range 600 {
+		key := fmt.Sprintf("rec:%d", i)
+		var v any = migrateRec{ID: i, Name: fmt.Sprintf("record %d", i)}
+		switch i % 5 {
+		case 1:
+			v = map[string]any{"id": float64(i), "tags": []any{"a", "b"}}
+		case 2:
+			v = fmt.Sprintf("plain %q string", key)
+		case 3:
+			v = migrateRec{ID: i, Name: strings.Repeat("long ", 150)}
+		}
+		rec := v5Record{value: v}
+		if i%7 == 0 {
+			err = db.InsertWithTTL(key, v, time.Hour)
+			rec.ttl = true
+		} else {
+			err = db.Insert(key, v)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%11 == 0 {
+			if err := db.Update(key, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var out any
+		if rec.version, _, err = db.SelectVersion(key, &out); err != nil {
+			t.Fatal(err)
+		}
+		want[key] = rec
+	}
+	err = db.write(func() error {
+		for i := 0; i < db.slots; i++ {
+			s, err := db.readSlot(i)
+			if err != nil {
+				return err
+			}
+			if s.state != StateOcc {
+				continue
+			}
+			env, err := db.slotEnvelope(s)
+			if err != nil {
+				return err
+			}
+			payload, err := json.Marshal(env)
+			if err != nil {
+				return err
+			}
+			if err := db.freeOverflow(s); err != nil {
+				return err
+			}
+			if err := db.writeRecord(i, s.hash, s.version, s.expires, payload); err != nil {
+				return err
+			}
+		}
+		db.hdr.Version = jsonEnvelopeVersion
+		return db.writeHeader()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return want
+}
+
+// crash abandons db as a killed process would, without a checkpoint, leaving
+// its WAL behind.
+func crash(db *DB) {
+	db.stopOnce.Do(func() { close(db.stop) })
+	db.bg.Wait()
+	_ = db.unmap()
+	_ = db.wal.Close()
+	_ = db.f.Close()
+	_ = db.lock.Close()
+	db.f = nil
+}
+
+// envelopeForms counts the occupied slots of db by the form of their envelope.
+func envelopeForms(t *testing.T, db *DB) (binary, json int) {
+	t.Helper()
+	for i := 0; i < db.slots; i++ {
+		s, err := db.readSlot(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.state != StateOcc {
+			continue
+		}
+		payload, err := db.payload(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload[0] == envBinary {
+			binary++
+		} else {
+			json++
+		}
+	}
+	return binary, json
+}
+
+// checkRecords fails unless db holds exactly the records in want.
+func checkRecords(t *testing.T, db *DB, want map[string]v5Record) {
+	t.Helper()
+	st, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Occupied != len(want) || st.Corrupt != 0 {
+		t.Fatalf("stats: %d occupied, %d corrupt; want %d, 0", st.Occupied, st.Corrupt, len(want))
+	}
+	for key, rec := range want {
+		got := reflect.New(reflect.TypeOf(rec.value))
+		version, ok, err := db.SelectVersion(key, got.Interface())
+		if err != nil || !ok {
+			t.Fatalf("select %s: %v, %v", key, ok, err)
+		}
+		if !reflect.DeepEqual(got.Elem().Interface(), rec.value) || version != rec.version {
+			t.Fatalf("select %s: %v version %d; want %v version %d", key, got.Elem(), version, rec.value, rec.version)
+		}
+		if _, hasTTL, err := db.TTL(key); err != nil || hasTTL != rec.ttl {
+			t.Fatalf("ttl %s: %v, %v; want %v", key, hasTTL, err, rec.ttl)
+		}
+	}
+	r, err := db.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Corrupt) != 0 {
+		t.Fatalf("verify: %v", r.Corrupt)
+	}
+}
+
+func TestMigrateEnvelopesOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "v5.bin")
+	want := writeV5(t, path)
+
+	db, err := Open(path, OpenOptions{Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v := db.Header().Version; v != FormatVersion {
+		t.Fatalf("version %d after migration, want %d", v, FormatVersion)
+	}
+	if bin, js := envelopeForms(t, db); bin != len(want) || js != 0 {
+		t.Fatalf("%d binary and %d JSON envelopes after migration, want %d and 0", bin, js, len(want))
+	}
+	checkRecords(t, db, want)
+
+	var r migrateRec
+	if err := db.Patch("rec:0", json.RawMessage(`{"name":"patched"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Select("rec:0", &r); err != nil || r.Name != "patched" {
+		t.Fatalf("after patch: %+v, %v", r, err)
+	}
+}
+
+func TestReadOnlyV5(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "v5.bin")
+	want := writeV5(t, path)
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(path, OpenOptions{Options: Options{ReapInterval: -1}, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := db.Header().Version; v != jsonEnvelopeVersion {
+		t.Fatalf("version %d, want %d", v, jsonEnvelopeVersion)
+	}
+	if bin, js := envelopeForms(t, db); bin != 0 || js != len(want) {
+		t.Fatalf("%d binary and %d JSON envelopes, want 0 and %d", bin, js, len(want))
+	}
+	checkRecords(t, db, want)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("read-only open changed the file")
+	}
+}
+
+func TestMigrateEnvelopesCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "v5.bin")
+	want := writeV5(t, path)
+	v5, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrate and crash before any checkpoint, then put the file back as it
+	// was and keep only the first half of the log: the crash hit half way
+	// through the migration, before the DB file saw any of it.
+	db, err := Open(path, OpenOptions{Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash(db)
+	wal, err := os.ReadFile(path + walSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, v5, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+walSuffix, wal[:len(wal)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replaying the log alone leaves the file half migrated.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := os.OpenFile(path+walSuffix, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := replayWAL(w, f, fileGeneration(f))
+	_ = w.Close()
+	_ = f.Close()
+	if err != nil || n == 0 {
+		t.Fatalf("replay: %d batches, %v", n, err)
+	}
+	if err := os.Truncate(path+walSuffix, 0); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(path, OpenOptions{Options: Options{ReapInterval: -1}, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, js := envelopeForms(t, db)
+	version := db.Header().Version
+	checkRecords(t, db, want)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if bin == 0 || js == 0 || version != jsonEnvelopeVersion {
+		t.Fatalf("after replay: %d binary and %d JSON envelopes, version %d; want both forms, version %d", bin, js, version, jsonEnvelopeVersion)
+	}
+
+	// The next read-write open finishes the migration.
+	db, err = Open(path, OpenOptions{Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v := db.Header().Version; v != FormatVersion {
+		t.Fatalf("version %d after migration, want %d", v, FormatVersion)
+	}
+	if bin, js := envelopeForms(t, db); bin != len(want) || js != 0 {
+		t.Fatalf("%d binary and %d JSON envelopes after migration, want %d and 0", bin, js, len(want))
+	}
+	checkRecords(t, db, want)
+}
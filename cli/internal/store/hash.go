@@ -0,0 +1,223 @@
+package store
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/bits"
+)
+
+// Hasher turns string keys into the 32-bit hashes stored in slot headers.
+// The algorithm and its seed are fixed when the file is created.
+type Hasher interface {
+	Hash(key string) uint32
+}
+
+// newHasher returns the Hasher for alg. seed is ignored by FNV-1a; xxHash64 uses
+// its first word, MurmurHash3 the low 32 bits of it, and SipHash both words as its key.
+func newHasher(alg HashAlg, seed [2]uint64) Hasher {
+	switch alg {
+	case HashSipHash:
+		return sipHasher{k0: seed[0], k1: seed[1]}
+	case HashXXH64:
+		return xxh64Hasher{seed: seed[0]}
+	case HashMurmur3:
+		return murmur3Hasher{seed: uint32(seed[0])}
+	}
+	return fnvHasher{}
+}
+
+// ExpandSeed derives the two words of CreateOptions.HashSeed from a single
+// 64-bit seed by running it through SplitMix64 twice, so that SipHash gets a
+// full 128-bit key. Zero stays zero, which still picks a random seed.
+func ExpandSeed(seed uint64) [2]uint64 {
+	if seed == 0 {
+		return [2]uint64{}
+	}
+	next := func() uint64 {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		return z ^ z>>31
+	}
+	return [2]uint64{next(), next()}
+}
+
+// fold64 mixes both halves of a 64-bit hash into 32 bits.
+func fold64(h uint64) uint32 {
+	return uint32(h) ^ uint32(h>>32)
+}
+
+// fnvHasher is 32-bit FNV-1a, the hash every file used before hashers were selectable.
+type fnvHasher struct{}
+
+func (fnvHasher) Hash(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// sipHasher is SipHash-2-4 keyed with k0, k1. Unlike the others it resists
+// keys chosen to collide by someone who does not know the key.
+type sipHasher struct{ k0, k1 uint64 }
+
+func (s sipHasher) Hash(key string) uint32 {
+	return fold64(sipHash24(s.k0, s.k1, []byte(key)))
+}
+
+func sipHash24(k0, k1 uint64, p []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+	n := len(p)
+	for ; len(p) >= 8; p = p[8:] {
+		m := binary.LittleEndian.Uint64(p)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+	b := uint64(n) << 56
+	for i, c := range p {
+		b |= uint64(c) << (8 * i)
+	}
+	v3 ^= b
+	round()
+	round()
+	v0 ^= b
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+// xxh64Hasher is xxHash64 with a seed.
+type xxh64Hasher struct{ seed uint64 }
+
+func (x xxh64Hasher) Hash(key string) uint32 {
+	return fold64(xxh64([]byte(key), x.seed))
+}
+
+const (
+	xxhP1 uint64 = 11400714785074694791
+	xxhP2 uint64 = 14029467366897019727
+	xxhP3 uint64 = 1609587929392839161
+	xxhP4 uint64 = 9650029242287828579
+	xxhP5 uint64 = 2870177450012600261
+)
+
+func xxhRound(acc, in uint64) uint64 {
+	return bits.RotateLeft64(acc+in*xxhP2, 31) * xxhP1
+}
+
+func xxhMerge(acc, v uint64) uint64 {
+	acc ^= xxhRound(0, v)
+	return acc*xxhP1 + xxhP4
+}
+
+func xxh64(p []byte, seed uint64) uint64 {
+	n := len(p)
+	var h uint64
+	if n >= 32 {
+		v1 := seed + xxhP1 + xxhP2
+		v2 := seed + xxhP2
+		v3 := seed
+		v4 := seed - xxhP1
+		for ; len(p) >= 32; p = p[32:] {
+			v1 = xxhRound(v1, binary.LittleEndian.Uint64(p[0:8]))
+			v2 = xxhRound(v2, binary.LittleEndian.Uint64(p[8:16]))
+			v3 = xxhRound(v3, binary.LittleEndian.Uint64(p[16:24]))
+			v4 = xxhRound(v4, binary.LittleEndian.Uint64(p[24:32]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxhMerge(h, v1)
+		h = xxhMerge(h, v2)
+		h = xxhMerge(h, v3)
+		h = xxhMerge(h, v4)
+	} else {
+		h = seed + xxhP5
+	}
+	h += uint64(n)
+	for ; len(p) >= 8; p = p[8:] {
+		h ^= xxhRound(0, binary.LittleEndian.Uint64(p))
+		h = bits.RotateLeft64(h, 27)*xxhP1 + xxhP4
+	}
+	if len(p) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(p)) * xxhP1
+		h = bits.RotateLeft64(h, 23)*xxhP2 + xxhP3
+		p = p[4:]
+	}
+	for _, c := range p {
+		h ^= uint64(c) * xxhP5
+		h = bits.RotateLeft64(h, 11) * xxhP1
+	}
+	h ^= h >> 33
+	h *= xxhP2
+	h ^= h >> 29
+	h *= xxhP3
+	h ^= h >> 32
+	return h
+}
+
+// murmur3Hasher is MurmurHash3 x86_32 with a seed.
+type murmur3Hasher struct{ seed uint32 }
+
+func (m murmur3Hasher) Hash(key string) uint32 {
+	return murmur3([]byte(key), m.seed)
+}
+
+func murmur3(p []byte, seed uint32) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	n := len(p)
+	h := seed
+	for ; len(p) >= 4; p = p[4:] {
+		k := binary.LittleEndian.Uint32(p)
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	var k uint32
+	switch len(p) {
+	case 3:
+		k ^= uint32(p[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(p[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(p[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(n)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
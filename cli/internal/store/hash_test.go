@@ -0,0 +1,68 @@
+package store
+
+import "testing"
+
+// The expected values are the reference vectors published with each algorithm.
+
+func TestSipHash24Vectors(t *testing.T) {
+	// Key 00 01 .. 0f, message 00 01 .. n-1 (vectors.h of the reference code).
+	const k0, k1 = 0x0706050403020100, 0x0f0e0d0c0b0a0908
+	want := map[int]uint64{
+		0:  0x726fdb47dd0e0e31,
+		1:  0x74f839c593dc67fd,
+		2:  0x0d6c8009d9a94f5a,
+		3:  0x85676696d7fb7e2d,
+		8:  0x93f5f5799a932462,
+		15: 0xa129ca6149be45e5,
+	}
+	for n, h := range want {
+		msg := make([]byte, n)
+		for i := range msg {
+			msg[i] = byte(i)
+		}
+		if got := sipHash24(k0, k1, msg); got != h {
+			t.Errorf("SipHash-2-4 of %d bytes = %#016x, want %#016x", n, got, h)
+		}
+	}
+}
+
+func TestXXH64Vectors(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		// Long enough for the four-lane loop.
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	} {
+		if got := xxh64([]byte(tc.in), 0); got != tc.want {
+			t.Errorf("xxHash64(%q) = %#016x, want %#016x", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestMurmur3Vectors(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		seed uint32
+		want uint32
+	}{
+		{"", 0, 0},
+		{"", 1, 0x514e28b7},
+		{"", 0xffffffff, 0x81f16f39},
+		{"\x00\x00\x00\x00", 0, 0x2362f9de},
+		{"a", 0x9747b28c, 0x7fa09ea6},
+		{"aa", 0x9747b28c, 0x5d211726},
+		{"aaa", 0x9747b28c, 0x283e0130},
+		{"aaaa", 0x9747b28c, 0x5a97808a},
+		{"abcd", 0x9747b28c, 0xf0478627},
+		{"Hello, world!", 0x9747b28c, 0x24884cba},
+		{"The quick brown fox jumps over the lazy dog", 0x9747b28c, 0x2fa826cd},
+	} {
+		if got := murmur3([]byte(tc.in), tc.seed); got != tc.want {
+			t.Errorf("MurmurHash3(%q, %#x) = %#08x, want %#08x", tc.in, tc.seed, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+// The first SlotSize bytes of every DB file hold a header page describing how
+// the rest of the file was laid out. Slot i lives at HeaderPageSize + i*SlotSize,
+// and the overflow region follows the last slot.
+//
+// Header page layout (little-endian):
+//
+//	magic[8] | version(uint16) | slotSize(uint16) | slots(uint32) | modPrime(uint32) |
+//	hashAlg(uint8) | probe(uint8) | deleteMode(uint8) | compression(uint8) | createdAt(int64, unix nanos) |
+//	resizes(uint32) | overflowPages(uint32) | overflowFreeHead(uint32) | overflowFree(uint32) |
+//	vacuums(uint32) | hashSeed[2](uint64) | baseSlots(uint32) | segments[24](uint32) |
+//	lastVersion(uint32) | ... zero padding ... | crc32(uint32) in the last 4 bytes
+const (
+	HeaderPageSize = SlotSize
+	FormatVersion  = 6
+)
+
+var magic = [8]byte{'K', 'D', 'B', 'H', 'A', 'S', 'H', 0}
+
+// HashAlg identifies the function used to turn string keys into slot hashes.
+type HashAlg uint8
+
+const (
+	HashFNV1a   HashAlg = 1
+	HashSipHash HashAlg = 2
+	HashXXH64   HashAlg = 3
+	HashMurmur3 HashAlg = 4
+)
+
+func (h HashAlg) String() string {
+	switch h {
+	case HashFNV1a:
+		return "fnv1a"
+	case HashSipHash:
+		return "siphash"
+	case HashXXH64:
+		return "xxhash64"
+	case HashMurmur3:
+		return "murmur3"
+	}
+	return fmt.Sprintf("hash(%d)", uint8(h))
+}
+
+// ProbeStrategy identifies the collision resolution scheme used by the table.
+type ProbeStrategy uint8
+
+const (
+	ProbeLinear    ProbeStrategy = 1
+	ProbeQuadratic ProbeStrategy = 2
+	ProbeDouble    ProbeStrategy = 3
+	ProbeRobinHood ProbeStrategy = 4
+	ProbeCuckoo    ProbeStrategy = 5
+	// ProbeLinearHashing grows the table one slot at a time (see linhash.go)
+	// and resolves collisions by linear probing.
+	ProbeLinearHashing ProbeStrategy = 6
+)
+
+func (p ProbeStrategy) String() string {
+	switch p {
+	case ProbeLinear:
+		return "linear"
+	case ProbeQuadratic:
+		return "quadratic"
+	case ProbeDouble:
+		return "double"
+	case ProbeRobinHood:
+		return "robin-hood"
+	case ProbeCuckoo:
+		return "cuckoo"
+	case ProbeLinearHashing:
+		return "linear-hashing"
+	}
+	return fmt.Sprintf("probe(%d)", uint8(p))
+}
+
+// DeleteMode identifies how Delete frees the slot of a record. Cuckoo tables
+// have no probe chains to keep intact and always empty the slot in place.
+type DeleteMode uint8
+
+const (
+	// DeleteTombstone marks the slot StateDeleted; the tombstone keeps probe chains
+	// intact and is reused by a later insert or dropped by the next rehash.
+	DeleteTombstone DeleteMode = 0
+	// DeleteBackwardShift empties the slot and moves the following entries of the
+	// cluster back toward their home slots, so the table never holds tombstones.
+	// It requires linear or Robin Hood probing.
+	DeleteBackwardShift DeleteMode = 1
+)
+
+func (m DeleteMode) String() string {
+	switch m {
+	case DeleteTombstone:
+		return "tombstone"
+	case DeleteBackwardShift:
+		return "backward-shift"
+	}
+	return fmt.Sprintf("delete(%d)", uint8(m))
+}
+
+// checkStrategies reports whether a probing strategy and a delete mode are known
+// and can be combined: backward shifting relies on the linear probe order, and
+// Robin Hood lookups cannot stop early past a tombstone.
+func checkStrategies(p ProbeStrategy, d DeleteMode) error {
+	switch p {
+	case ProbeLinear, ProbeQuadratic, ProbeDouble, ProbeRobinHood, ProbeCuckoo, ProbeLinearHashing:
+	default:
+		return fmt.Errorf("unknown probing strategy %d", p)
+	}
+	switch d {
+	case DeleteTombstone, DeleteBackwardShift:
+	default:
+		return fmt.Errorf("unknown delete mode %d", d)
+	}
+	if d == DeleteBackwardShift && (p == ProbeQuadratic || p == ProbeDouble || p == ProbeCuckoo) {
+		return fmt.Errorf("%s deletion requires linear or %s probing, not %s", d, ProbeRobinHood, p)
+	}
+	if p == ProbeRobinHood && d != DeleteBackwardShift {
+		return fmt.Errorf("%s probing requires %s deletion", p, DeleteBackwardShift)
+	}
+	return nil
+}
+
+var (
+	ErrExists             = errors.New("database file already exists")
+	ErrNotExist           = errors.New("database file does not exist")
+	ErrBadMagic           = errors.New("not a database file (bad magic)")
+	ErrUnsupportedVersion = errors.New("unsupported format version")
+	ErrBadHeader          = errors.New("corrupt file header")
+	ErrHeaderMismatch     = errors.New("file header does not match options")
+)
+
+// MismatchError reports a header field that differs from what the caller asked for.
+// It matches ErrHeaderMismatch with errors.Is.
+type MismatchError struct {
+	Field string
+	Want  any
+	Got   any
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("%v: %s is %v in file, want %v", ErrHeaderMismatch, e.Field, e.Got, e.Want)
+}
+
+func (e *MismatchError) Is(target error) bool { return target == ErrHeaderMismatch }
+
+// Header is the decoded header page of a DB file.
+type Header struct {
+	Version   uint16
+	SlotSize  uint16
+	Slots     uint32
+	ModPrime  uint32
+	Hash      HashAlg
+	Probe     ProbeStrategy
+	CreatedAt time.Time
+	// Delete is how deleted records free their slot.
+	Delete DeleteMode
+	// Compression is how record payloads are compressed. Files from before it
+	// existed have zero there, which is CompressNone.
+	Compression Compression
+	// Resizes counts how many times the table has been rehashed into a new size.
+	Resizes uint32
+	// OverflowPages is the number of pages in the overflow region.
+	OverflowPages uint32
+	// OverflowFreeHead is the first page of the overflow free list (1-based, 0 = empty)
+	// and OverflowFree is the length of that list.
+	OverflowFreeHead uint32
+	OverflowFree     uint32
+	// Vacuums counts how many times the table has been rebuilt in place by Vacuum.
+	Vacuums uint32
+	// HashSeed keys the hash function; it is zero for FNV-1a.
+	HashSeed [2]uint64
+	// BaseSlots is the slot count a linear hashing table started from; those slots
+	// follow the header page. Segments[k] is the first page of the region holding
+	// slots [BaseSlots<<k, BaseSlots<<(k+1)), allocated from the overflow region.
+	BaseSlots uint32
+	Segments  [maxSegments]uint32
+	// LastVersion is the highest record version the table has handed out. A new
+	// record gets the next one, so a key deleted and inserted again never gets back
+	// a version it had before. Files from before it existed have zero there, and
+	// Open raises it to the highest version of a live record.
+	LastVersion uint32
+}
+
+// maxSegments bounds how many times a linear hashing table can double.
+const maxSegments = 24
+
+// inlineSlots is the number of slots stored right after the header page, before
+// the overflow region.
+func (h *Header) inlineSlots() uint32 {
+	if h.Probe == ProbeLinearHashing {
+		return h.BaseSlots
+	}
+	return h.Slots
+}
+
+// generation changes whenever the table is rebuilt and records may change slots.
+func (h *Header) generation() uint32 {
+	return h.Resizes + h.Vacuums
+}
+
+func (h *Header) encode() []byte {
+	buf := make([]byte, HeaderPageSize)
+	copy(buf[0:8], magic[:])
+	binary.LittleEndian.PutUint16(buf[8:10], h.Version)
+	binary.LittleEndian.PutUint16(buf[10:12], h.SlotSize)
+	binary.LittleEndian.PutUint32(buf[12:16], h.Slots)
+	binary.LittleEndian.PutUint32(buf[16:20], h.ModPrime)
+	buf[20] = byte(h.Hash)
+	buf[21] = byte(h.Probe)
+	buf[22] = byte(h.Delete)
+	buf[23] = byte(h.Compression)
+	binary.LittleEndian.PutUint64(buf[24:32], uint64(h.CreatedAt.UnixNano()))
+	binary.LittleEndian.PutUint32(buf[32:36], h.Resizes)
+	binary.LittleEndian.PutUint32(buf[36:40], h.OverflowPages)
+	binary.LittleEndian.PutUint32(buf[40:44], h.OverflowFreeHead)
+	binary.LittleEndian.PutUint32(buf[44:48], h.OverflowFree)
+	binary.LittleEndian.PutUint32(buf[48:52], h.Vacuums)
+	binary.LittleEndian.PutUint64(buf[52:60], h.HashSeed[0])
+	binary.LittleEndian.PutUint64(buf[60:68], h.HashSeed[1])
+	binary.LittleEndian.PutUint32(buf[68:72], h.BaseSlots)
+	for k, page := range h.Segments {
+		binary.LittleEndian.PutUint32(buf[72+4*k:], page)
+	}
+	binary.LittleEndian.PutUint32(buf[72+4*maxSegments:], h.LastVersion)
+	binary.LittleEndian.PutUint32(buf[HeaderPageSize-4:], crc32.ChecksumIEEE(buf[:HeaderPageSize-4]))
+	return buf
+}
+
+func decodeHeader(buf []byte) (*Header, error) {
+	if len(buf) < HeaderPageSize {
+		return nil, fmt.Errorf("%w: short header", ErrBadHeader)
+	}
+	if [8]byte(buf[0:8]) != magic {
+		return nil, ErrBadMagic
+	}
+	h := &Header{
+		Version:     binary.LittleEndian.Uint16(buf[8:10]),
+		SlotSize:    binary.LittleEndian.Uint16(buf[10:12]),
+		Slots:       binary.LittleEndian.Uint32(buf[12:16]),
+		ModPrime:    binary.LittleEndian.Uint32(buf[16:20]),
+		Hash:        HashAlg(buf[20]),
+		Probe:       ProbeStrategy(buf[21]),
+		Delete:      DeleteMode(buf[22]),
+		Compression: Compression(buf[23]),
+		CreatedAt:   time.Unix(0, int64(binary.LittleEndian.Uint64(buf[24:32]))).UTC(),
+		Resizes:     binary.LittleEndian.Uint32(buf[32:36]),
+
+		OverflowPages:    binary.LittleEndian.Uint32(buf[36:40]),
+		OverflowFreeHead: binary.LittleEndian.Uint32(buf[40:44]),
+		OverflowFree:     binary.LittleEndian.Uint32(buf[44:48]),
+		Vacuums:          binary.LittleEndian.Uint32(buf[48:52]),
+		HashSeed:         [2]uint64{binary.LittleEndian.Uint64(buf[52:60]), binary.LittleEndian.Uint64(buf[60:68])},
+		BaseSlots:        binary.LittleEndian.Uint32(buf[68:72]),
+	}
+	for k := range h.Segments {
+		h.Segments[k] = binary.LittleEndian.Uint32(buf[72+4*k:])
+	}
+	h.LastVersion = binary.LittleEndian.Uint32(buf[72+4*maxSegments:])
+	if h.Version < jsonEnvelopeVersion || h.Version > FormatVersion {
+		return nil, fmt.Errorf("%w: %d (want %d to %d)", ErrUnsupportedVersion, h.Version, jsonEnvelopeVersion, FormatVersion)
+	}
+	if crc := binary.LittleEndian.Uint32(buf[HeaderPageSize-4:]); crc != crc32.ChecksumIEEE(buf[:HeaderPageSize-4]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrBadHeader)
+	}
+	if h.SlotSize != SlotSize {
+		return nil, &MismatchError{Field: "slot size", Want: SlotSize, Got: h.SlotSize}
+	}
+	if h.Slots == 0 || h.ModPrime == 0 {
+		return nil, fmt.Errorf("%w: zero slot count", ErrBadHeader)
+	}
+	if h.Hash < HashFNV1a || h.Hash > HashMurmur3 {
+		return nil, fmt.Errorf("%w: unknown hash algorithm %d", ErrBadHeader, h.Hash)
+	}
+	if err := checkStrategies(h.Probe, h.Delete); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadHeader, err)
+	}
+	if h.Compression > CompressDeflate {
+		return nil, fmt.Errorf("%w: unknown compression %d", ErrBadHeader, h.Compression)
+	}
+	if h.Probe == ProbeLinearHashing && (h.BaseSlots == 0 || h.BaseSlots > h.Slots || uint64(h.Slots) > uint64(h.BaseSlots)<<maxSegments) {
+		return nil, fmt.Errorf("%w: bad linear hashing base %d for %d slots", ErrBadHeader, h.BaseSlots, h.Slots)
+	}
+	return h, nil
+}
+
+// fileSize returns the size a file described by h must have.
+func (h *Header) fileSize() int64 {
+	return HeaderPageSize + (int64(h.inlineSlots())+int64(h.OverflowPages))*SlotSize
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestOpenMismatch(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(db.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open(db.path, OpenOptions{Slots: 53, Options: Options{ReapInterval: -1}})
+	var me *MismatchError
+	if !errors.As(err, &me) || !errors.Is(err, ErrHeaderMismatch) {
+		t.Fatalf("Open with another slot count: %v", err)
+	}
+	if me.Field != "slots" || me.Want != 53 || me.Got != uint32(31) {
+		t.Fatalf("MismatchError %+v", me)
+	}
+	// The file is left as it was rather than resized to the options.
+	if st, err := os.Stat(db.path); err != nil || st.Size() != before.Size() {
+		t.Fatalf("file changed from %d bytes: %v, %v", before.Size(), st, err)
+	}
+
+	if err := os.Truncate(db.path, before.Size()-SlotSize); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open(db.path, OpenOptions{Options: Options{ReapInterval: -1}})
+	if !errors.As(err, &me) || me.Field != "file size" {
+		t.Fatalf("Open of a truncated file: %v", err)
+	}
+
+	if err := os.WriteFile(db.path, bytes.Repeat([]byte("junk"), HeaderPageSize/2), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(db.path, OpenOptions{Options: Options{ReapInterval: -1}}); !errors.Is(err, ErrBadMagic) {
+		t.Fatalf("Open of a file without a header: %v", err)
+	}
+}
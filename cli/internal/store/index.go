@@ -0,0 +1,533 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Secondary indexes map the value of a JSON field of matching records to the keys
+// of those records. Each index is itself a DB file (<db>.idx-<name>) whose keys
+// are the canonical JSON encoding of a field value and whose values are the
+// sorted list of primary keys holding it. The catalog (<db>.indexes) lists the
+// index definitions. As every change rewrites the whole list of its value, an
+// index suits fields with few records per value; one over a field most records
+// share makes each write cost O(n) in the size of the table.
+//
+// Index changes are computed inside the write batch of the record change, so a
+// unique violation aborts the whole batch, and applied to the index files right
+// after it commits. The catalog is marked dirty while the DB is open; finding it
+// dirty on Open means the process died, possibly between the two steps, and every
+// index is rebuilt from the table. An index whose update fails after the commit
+// does not fail the write, which is durable by then: the index is marked stale
+// instead, Lookup fails with ErrIndexStale and writes stop maintaining it, unique
+// checks included, until the next Open rebuilds it.
+const (
+	catalogSuffix = ".indexes"
+	indexSuffix   = ".idx-"
+	indexSlots    = 64
+)
+
+var (
+	ErrIndexExists   = errors.New("index already exists")
+	ErrIndexNotFound = errors.New("index not found")
+	ErrBadIndexName  = errors.New("index name must match [A-Za-z0-9_-]+")
+	ErrIndexStale    = errors.New("index is stale until the DB is reopened")
+)
+
+var indexName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// indexCreateOptions are used for the index files. Their records never expire,
+// so they need no reaper.
+var indexCreateOptions = CreateOptions{Slots: indexSlots, Options: Options{ReapInterval: -1}}
+
+// ErrUniqueViolation is returned when a write would give two records the same
+// value in a unique index.
+type ErrUniqueViolation struct {
+	Index    string
+	Value    string
+	Existing string
+}
+
+func (e *ErrUniqueViolation) Error() string {
+	return fmt.Sprintf("unique index %s: value %s already used by %q", e.Index, e.Value, e.Existing)
+}
+
+// IndexDef describes a secondary index.
+type IndexDef struct {
+	Name string `json:"name"`
+	// Match selects the indexed records: those whose stored type name equals it
+	// or whose key starts with it. Empty matches every record.
+	Match string `json:"match"`
+	// Path is the dotted path of the indexed field inside the record, e.g. "email"
+	// or "address.city".
+	Path   string `json:"path"`
+	Unique bool   `json:"unique"`
+}
+
+type catalog struct {
+	Clean   bool       `json:"clean"`
+	Indexes []IndexDef `json:"indexes"`
+}
+
+type index struct {
+	def IndexDef
+	db  *DB
+	// stale is the error that left the index behind the table, if any.
+	stale error
+}
+
+// indexOp adds key to or removes it from the entry for value.
+type indexOp struct {
+	ix    *index
+	value string
+	key   string
+	add   bool
+}
+
+// value extracts the canonical indexed value of env, or reports false if the
+// record is not covered by the index.
+func (ix *index) value(key string, env *envelope) (string, bool) {
+	if env == nil {
+		return "", false
+	}
+	if m := ix.def.Match; m != "" && env.Type != m && !strings.HasPrefix(key, m) {
+		return "", false
+	}
+	var v any
+	if err := unmarshalNumber(env.Data, &v); err != nil {
+		return "", false
+	}
+	for _, part := range strings.Split(ix.def.Path, ".") {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		v = obj[part]
+	}
+	if v == nil {
+		return "", false
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// keys returns the primary keys stored under value.
+func (ix *index) keys(value string) ([]string, error) {
+	var keys []string
+	if _, err := ix.db.Select(value, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// canonicalValue encodes a lookup value the way index.value does.
+func canonicalValue(value any) (string, error) {
+	raw, ok := value.(json.RawMessage)
+	if !ok {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		raw = b
+	}
+	var v any
+	if err := unmarshalNumber(raw, &v); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// indexChange queues the index updates for key going from old to new (either may
+// be nil) and checks unique indexes. Caller runs it inside db.write.
+func (db *DB) indexChange(key string, old, new *envelope) error {
+	for _, ix := range db.indexes {
+		if ix.stale != nil {
+			continue
+		}
+		ov, hadOld := ix.value(key, old)
+		nv, hasNew := ix.value(key, new)
+		if hadOld && hasNew && ov == nv {
+			continue
+		}
+		if hasNew && ix.def.Unique {
+			keys, err := db.pendingKeys(ix, nv)
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				if k != key {
+					return &ErrUniqueViolation{Index: ix.def.Name, Value: nv, Existing: k}
+				}
+			}
+		}
+		if hadOld {
+			db.idxOps = append(db.idxOps, indexOp{ix: ix, value: ov, key: key})
+		}
+		if hasNew {
+			db.idxOps = append(db.idxOps, indexOp{ix: ix, value: nv, key: key, add: true})
+		}
+	}
+	return nil
+}
+
+// pendingKeys is ix.keys(value) as it will be once the queued operations are applied.
+func (db *DB) pendingKeys(ix *index, value string) ([]string, error) {
+	keys, err := ix.keys(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range db.idxOps {
+		if op.ix == ix && op.value == value {
+			keys = applyIndexOp(keys, op)
+		}
+	}
+	return keys, nil
+}
+
+func applyIndexOp(keys []string, op indexOp) []string {
+	i, found := slices.BinarySearch(keys, op.key)
+	switch {
+	case op.add && !found:
+		keys = slices.Insert(keys, i, op.key)
+	case !op.add && found:
+		keys = slices.Delete(keys, i, i+1)
+	}
+	return keys
+}
+
+// applyIndexOps writes the operations queued by a committed batch to the index
+// files, marking stale any index it fails to update. Caller holds db.mu exclusively.
+func (db *DB) applyIndexOps(ops []indexOp) {
+	for _, op := range ops {
+		if op.ix.stale != nil {
+			continue
+		}
+		keys, err := op.ix.keys(op.value)
+		if err == nil {
+			keys = applyIndexOp(keys, op)
+			if len(keys) == 0 {
+				_, err = op.ix.db.Delete(op.value)
+			} else {
+				err = op.ix.db.Upsert(op.value, keys)
+			}
+		}
+		if err != nil {
+			op.ix.stale = err
+		}
+	}
+}
+
+// indexesStale reports whether some index missed an update since Open.
+func (db *DB) indexesStale() bool {
+	for _, ix := range db.indexes {
+		if ix.stale != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateIndex adds a secondary index on the JSON field at jsonPath of the records
+// selected by typeOrPrefix (see IndexDef.Match) and fills it from the existing
+// records. With unique set, it fails with *ErrUniqueViolation if two records
+// already share a value, and later writes that would do so fail the same way.
+func (db *DB) CreateIndex(name, typeOrPrefix, jsonPath string, unique bool) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	if !indexName.MatchString(name) {
+		return ErrBadIndexName
+	}
+	if jsonPath == "" {
+		return fmt.Errorf("empty index path")
+	}
+	db.wmu.Lock()
+	defer db.wmu.Unlock()
+
+	db.mu.RLock()
+	_, exists := db.indexes[name]
+	db.mu.RUnlock()
+	if exists {
+		return fmt.Errorf("%w: %s", ErrIndexExists, name)
+	}
+	path := db.path + indexSuffix + name
+	_ = os.Remove(path)
+	idb, err := Create(path, indexCreateOptions)
+	if err != nil {
+		return err
+	}
+	ix := &index{def: IndexDef{Name: name, Match: typeOrPrefix, Path: jsonPath, Unique: unique}, db: idb}
+	if err := db.backfill(ix); err != nil {
+		_ = idb.Close()
+		removeDBFiles(path)
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.indexes[name] = ix
+	if err := db.saveCatalog(false); err != nil {
+		delete(db.indexes, name)
+		_ = idb.Close()
+		removeDBFiles(path)
+		return err
+	}
+	return nil
+}
+
+// DropIndex removes a secondary index and its file.
+func (db *DB) DropIndex(name string) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.wmu.Lock()
+	defer db.wmu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	ix, ok := db.indexes[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrIndexNotFound, name)
+	}
+	delete(db.indexes, name)
+	if err := db.saveCatalog(false); err != nil {
+		db.indexes[name] = ix
+		return err
+	}
+	err := ix.db.Close()
+	removeDBFiles(ix.db.path)
+	return err
+}
+
+// Indexes returns the definitions of the secondary indexes, sorted by name.
+func (db *DB) Indexes() []IndexDef {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	defs := make([]IndexDef, 0, len(db.indexes))
+	for _, ix := range db.indexes {
+		defs = append(defs, ix.def)
+	}
+	slices.SortFunc(defs, func(a, b IndexDef) int { return strings.Compare(a.Name, b.Name) })
+	return defs
+}
+
+// Lookup returns the sorted keys of the records whose indexed field equals value.
+// value is any JSON-encodable value; a json.RawMessage is used as is.
+func (db *DB) Lookup(name string, value any) ([]string, error) {
+	v, err := canonicalValue(value)
+	if err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	ix, ok := db.indexes[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrIndexNotFound, name)
+	}
+	if ix.stale != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrIndexStale, name, ix.stale)
+	}
+	keys, err := ix.keys(v)
+	if err != nil || !db.hasTTL {
+		return keys, err
+	}
+	// Expired records stay indexed until they are reclaimed.
+	live := keys[:0]
+	for _, key := range keys {
+		_, _, env, err := db.find(key, db.hashKey(key))
+		if err != nil {
+			return nil, err
+		}
+		if env != nil {
+			live = append(live, key)
+		}
+	}
+	return live, nil
+}
+
+// backfill fills the empty index ix from every record of the table.
+// Caller holds db.wmu, so the table does not change meanwhile.
+func (db *DB) backfill(ix *index) error {
+	entries := make(map[string][]string)
+	db.mu.RLock()
+	for i := 0; i < db.slots; i++ {
+		s, err := db.readSlot(i)
+		if err != nil {
+			db.mu.RUnlock()
+			return err
+		}
+		if s.state != StateOcc {
+			continue
+		}
+		env, err := db.slotEnvelope(s)
+		if err != nil {
+			db.mu.RUnlock()
+			return err
+		}
+		v, ok := ix.value(env.Key, env)
+		if !ok {
+			continue
+		}
+		if ix.def.Unique && len(entries[v]) > 0 {
+			db.mu.RUnlock()
+			return &ErrUniqueViolation{Index: ix.def.Name, Value: v, Existing: entries[v][0]}
+		}
+		entries[v] = append(entries[v], env.Key)
+	}
+	db.mu.RUnlock()
+
+	tx := ix.db.Begin()
+	for v, keys := range entries {
+		slices.Sort(keys)
+		if err := tx.Insert(v, keys); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// openIndexes loads the catalog and opens every index file, rebuilding them all
+// if the DB was not closed cleanly. The catalog is then marked dirty until Close.
+func (db *DB) openIndexes() error {
+	db.indexes = make(map[string]*index)
+	b, err := os.ReadFile(db.path + catalogSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var cat catalog
+	if err := json.Unmarshal(b, &cat); err != nil {
+		return fmt.Errorf("index catalog: %w", err)
+	}
+	if db.readOnly && !cat.Clean {
+		return fmt.Errorf("indexes: %w", ErrNeedsRecovery)
+	}
+	for _, def := range cat.Indexes {
+		path := db.path + indexSuffix + def.Name
+		opts := OpenOptions{CreateIfMissing: true, Create: indexCreateOptions, Options: indexCreateOptions.Options}
+		opts.ReadOnly = db.readOnly
+		idb, err := Open(path, opts)
+		if err != nil {
+			_ = db.closeIndexes()
+			return fmt.Errorf("index %s: %w", def.Name, err)
+		}
+		ix := &index{def: def, db: idb}
+		db.indexes[def.Name] = ix
+		if cat.Clean {
+			continue
+		}
+		if err := idb.Clear(); err != nil {
+			_ = db.closeIndexes()
+			return fmt.Errorf("index %s: %w", def.Name, err)
+		}
+		if err := db.backfill(ix); err != nil {
+			_ = db.closeIndexes()
+			return fmt.Errorf("rebuild index %s: %w", def.Name, err)
+		}
+	}
+	if len(db.indexes) == 0 || db.readOnly {
+		return nil
+	}
+	return db.saveCatalog(false)
+}
+
+func (db *DB) closeIndexes() error {
+	var err error
+	for _, ix := range db.indexes {
+		// A stale index is rebuilt on the next Open whatever state it is left in.
+		if cerr := ix.db.Close(); err == nil && ix.stale == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// clearIndexes empties every index, as after Clear.
+func (db *DB) clearIndexes() error {
+	for _, ix := range db.indexes {
+		if err := ix.db.Clear(); err != nil {
+			return fmt.Errorf("index %s: %w", ix.def.Name, err)
+		}
+		// An empty index matches the cleared table again.
+		db.mu.Lock()
+		ix.stale = nil
+		db.mu.Unlock()
+	}
+	return nil
+}
+
+// saveCatalog atomically rewrites the catalog, or removes it when there are no indexes.
+func (db *DB) saveCatalog(clean bool) error {
+	path := db.path + catalogSuffix
+	if len(db.indexes) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	cat := catalog{Clean: clean}
+	for _, ix := range db.indexes {
+		cat.Indexes = append(cat.Indexes, ix.def)
+	}
+	slices.SortFunc(cat.Indexes, func(a, b IndexDef) int { return strings.Compare(a.Name, b.Name) })
+	b, err := json.MarshalIndent(cat, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// removeDBFiles deletes a DB file and its log.
+func removeDBFiles(path string) {
+	_ = os.Remove(path)
+	_ = os.Remove(path + walSuffix)
+	_ = os.Remove(path + lockSuffix)
+}
+
+// removeIndexFiles deletes the catalog, index files and key index left by an
+// earlier DB at path.
+func removeIndexFiles(path string) error {
+	for _, p := range []string{path + catalogSuffix, path + keyIndexSuffix} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	matches, err := filepath.Glob(globEscape(path) + indexSuffix + "*")
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		if err := os.Remove(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func globEscape(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
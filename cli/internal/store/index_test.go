@@ -0,0 +1,175 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+)
+
+type indexedUser struct {
+	Email   string `json:"email"`
+	Address struct {
+		City string `json:"city"`
+	} `json:"address"`
+}
+
+func newIndexedUser(email, city string) indexedUser {
+	u := indexedUser{Email: email}
+	u.Address.City = city
+	return u
+}
+
+func wantLookup(t *testing.T, db *DB, name string, value any, want ...string) {
+	t.Helper()
+	got, err := db.Lookup(name, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Lookup(%s, %v) = %q, want %q", name, value, got, want)
+	}
+}
+
+func TestIndexNonUnique(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 61})
+	for key, u := range map[string]indexedUser{
+		"user:1": newIndexedUser("a@x", "Kyiv"),
+		"user:2": newIndexedUser("b@x", "Kyiv"),
+		"user:3": newIndexedUser("c@x", "Lviv"),
+	} {
+		if err := db.Insert(key, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Not matched by the index: another key prefix.
+	if err := db.Insert("admin:1", newIndexedUser("d@x", "Kyiv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateIndex("city", "user:", "address.city", false); err != nil {
+		t.Fatal(err)
+	}
+	wantLookup(t, db, "city", "Kyiv", "user:1", "user:2")
+
+	// Every change rewrites the sorted key lists of the old and the new value.
+	if err := db.Insert("user:0", newIndexedUser("e@x", "Kyiv")); err != nil {
+		t.Fatal(err)
+	}
+	wantLookup(t, db, "city", "Kyiv", "user:0", "user:1", "user:2")
+	if err := db.Update("user:1", newIndexedUser("a@x", "Lviv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Patch("user:2", json.RawMessage(`{"address":{"city":"Odesa"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	wantLookup(t, db, "city", "Kyiv", "user:0")
+	wantLookup(t, db, "city", "Lviv", "user:1", "user:3")
+	wantLookup(t, db, "city", "Odesa", "user:2")
+	if _, err := db.Delete("user:0"); err != nil {
+		t.Fatal(err)
+	}
+	wantLookup(t, db, "city", "Kyiv")
+	// Dropping the field takes the record out of the index.
+	if err := db.Patch("user:3", json.RawMessage(`{"address":null}`)); err != nil {
+		t.Fatal(err)
+	}
+	wantLookup(t, db, "city", "Lviv", "user:1")
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(db.path, OpenOptions{Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	wantLookup(t, db, "city", "Lviv", "user:1")
+	if defs := db.Indexes(); len(defs) != 1 || defs[0].Path != "address.city" {
+		t.Fatalf("Indexes after reopen: %+v", defs)
+	}
+	if err := db.DropIndex("city"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Lookup("city", "Lviv"); !errors.Is(err, ErrIndexNotFound) {
+		t.Fatalf("Lookup on a dropped index: %v", err)
+	}
+}
+
+func TestIndexUnique(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 61})
+	if err := db.Insert("user:1", newIndexedUser("a@x", "Kyiv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("user:2", newIndexedUser("a@x", "Lviv")); err != nil {
+		t.Fatal(err)
+	}
+	var uv *ErrUniqueViolation
+	if err := db.CreateIndex("email", "", "email", true); !errors.As(err, &uv) {
+		t.Fatalf("CreateIndex over duplicate values: %v", err)
+	}
+	if err := db.Update("user:2", newIndexedUser("b@x", "Lviv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateIndex("email", "", "email", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Insert("user:3", newIndexedUser("a@x", "Odesa")); !errors.As(err, &uv) || uv.Existing != "user:1" {
+		t.Fatalf("Insert of a duplicate: %v", err)
+	}
+	var u indexedUser
+	if found, err := db.Select("user:3", &u); err != nil || found {
+		t.Fatalf("rejected insert stored the record: found=%v, %v", found, err)
+	}
+	// Rewriting a record with its own value is not a violation.
+	if err := db.Update("user:1", newIndexedUser("a@x", "Dnipro")); err != nil {
+		t.Fatal(err)
+	}
+	// Within one batch, a value freed by one write can be taken by the next.
+	tx := db.Begin()
+	if err := tx.Update("user:1", newIndexedUser("c@x", "Dnipro")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Insert("user:3", newIndexedUser("a@x", "Odesa")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	wantLookup(t, db, "email", "a@x", "user:3")
+	wantLookup(t, db, "email", "c@x", "user:1")
+}
+
+func TestIndexStaleAfterFailedUpdate(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 61})
+	if err := db.CreateIndex("city", "user:", "address.city", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("user:1", newIndexedUser("a@x", "Kyiv")); err != nil {
+		t.Fatal(err)
+	}
+	// The index file going away fails its update only after the batch commits.
+	if err := db.indexes["city"].db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("user:2", newIndexedUser("b@x", "Lviv")); err != nil {
+		t.Fatalf("Insert with a failing index: %v", err)
+	}
+	if err := db.Update("user:1", newIndexedUser("a@x", "Lviv")); err != nil {
+		t.Fatalf("Update with a stale index: %v", err)
+	}
+	if _, err := db.Lookup("city", "Kyiv"); !errors.Is(err, ErrIndexStale) {
+		t.Fatalf("Lookup on a stale index: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(db.path, OpenOptions{Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	wantLookup(t, db, "city", "Kyiv")
+	wantLookup(t, db, "city", "Lviv", "user:1", "user:2")
+}
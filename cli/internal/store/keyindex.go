@@ -0,0 +1,218 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// The key index (<db>.keys, enabled with Options.KeyIndex) is a B+tree mapping
+// every key to the slot holding it, so keys can be listed in order. Like the
+// secondary indexes it is updated right after each batch commits: writeSlot
+// queues the new position of every record it writes, which covers inserts as
+// well as records moved by Robin Hood, cuckoo, backward-shift or a split, and
+// delete queues the removal. A resize, vacuum or clear moves everything, so the
+// tree is rebuilt from the table instead, as it is on Open when the last session
+// did not close it cleanly. If an update fails after the commit, the write still
+// succeeds: the tree is marked stale, Range and Prefix fail with ErrIndexStale
+// and it is left unclean for the next Open to rebuild, unless a resize, vacuum
+// or clear rebuilds it first.
+const keyIndexSuffix = ".keys"
+
+var ErrNoKeyIndex = errors.New("key index not enabled")
+
+// keyOp sets the slot of key, or removes key when del is set.
+type keyOp struct {
+	key  string
+	slot uint32
+	del  bool
+}
+
+// openKeyIndex opens the key index if it is enabled, rebuilding it when needed,
+// and otherwise removes a leftover one, which would go stale. A read-only DB
+// leaves the file alone and cannot rebuild it.
+func (db *DB) openKeyIndex() error {
+	path := db.path + keyIndexSuffix
+	if db.readOnly {
+		if !db.opts.KeyIndex {
+			return nil
+		}
+		t, clean, err := openBTreeReadOnly(path)
+		if err != nil {
+			return fmt.Errorf("key index: %w", err)
+		}
+		if !clean || t.count != uint64(db.used) {
+			if t != nil {
+				_ = t.close(false)
+			}
+			return fmt.Errorf("key index: %w", ErrNeedsRecovery)
+		}
+		db.keys = t
+		return nil
+	}
+	if !db.opts.KeyIndex {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	t, clean, err := openBTree(path)
+	if err != nil {
+		return fmt.Errorf("key index: %w", err)
+	}
+	db.keys = t
+	if clean && t.count == uint64(db.used) {
+		return nil
+	}
+	if err := db.rebuildKeyIndex(); err != nil {
+		_ = t.close(false)
+		db.keys = nil
+		return err
+	}
+	return nil
+}
+
+// noteSlot queues the position of the record written to slot index.
+// Caller runs it inside db.write.
+func (db *DB) noteSlot(index int, s slot) error {
+	env, err := db.slotEnvelope(s)
+	if err != nil {
+		return err
+	}
+	if len(env.Key) > MaxIndexedKeyLen {
+		return fmt.Errorf("key index: key longer than %d bytes", MaxIndexedKeyLen)
+	}
+	db.keyOps = append(db.keyOps, keyOp{key: env.Key, slot: uint32(index)})
+	return nil
+}
+
+// applyKeyOps writes the operations queued by a committed batch to the key index,
+// marking it stale if that fails. Caller holds db.mu exclusively.
+func (db *DB) applyKeyOps(ops []keyOp) {
+	for _, op := range ops {
+		if db.keysStale != nil {
+			return
+		}
+		if op.del {
+			_, db.keysStale = db.keys.delete(op.key)
+		} else {
+			db.keysStale = db.keys.put(op.key, op.slot)
+		}
+	}
+}
+
+// rebuildKeyIndex reloads the key index from the table. Caller holds db.mu
+// exclusively or is the only user of db.
+func (db *DB) rebuildKeyIndex() error {
+	type entry struct {
+		key  string
+		slot uint32
+	}
+	var entries []entry
+	for i := 0; i < db.slots; i++ {
+		s, err := db.readSlot(i)
+		if err != nil {
+			return err
+		}
+		if s.state != StateOcc {
+			continue
+		}
+		env, err := db.slotEnvelope(s)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{env.Key, uint32(i)})
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(a.key, b.key) })
+	keys := make([]string, len(entries))
+	slots := make([]uint32, len(entries))
+	for i, e := range entries {
+		keys[i], slots[i] = e.key, e.slot
+	}
+	if err := db.keys.load(keys, slots); err != nil {
+		return fmt.Errorf("key index: %w", err)
+	}
+	db.keysStale = nil
+	return nil
+}
+
+// Range returns up to limit keys k with from <= k <= to in ascending order. An
+// empty to means no upper bound and a limit <= 0 means no limit. To read the
+// next page, call Range again with from set to the last key returned plus "\x00".
+// Fails with ErrNoKeyIndex unless the DB was opened with Options.KeyIndex.
+func (db *DB) Range(from, to string, limit int) ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.keys == nil {
+		return nil, ErrNoKeyIndex
+	}
+	if db.keysStale != nil {
+		return nil, fmt.Errorf("key index: %w: %v", ErrIndexStale, db.keysStale)
+	}
+	var keys []string
+	var ferr error
+	err := db.keys.scan(from, func(key string, idx uint32) bool {
+		if to != "" && key > to {
+			return false
+		}
+		var live bool
+		if live, ferr = db.liveAt(idx); ferr != nil {
+			return false
+		}
+		if live {
+			keys = append(keys, key)
+		}
+		return limit <= 0 || len(keys) < limit
+	})
+	if err == nil {
+		err = ferr
+	}
+	return keys, err
+}
+
+// Prefix returns the keys starting with prefix in ascending order.
+// Fails with ErrNoKeyIndex unless the DB was opened with Options.KeyIndex.
+func (db *DB) Prefix(prefix string) ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.keys == nil {
+		return nil, ErrNoKeyIndex
+	}
+	if db.keysStale != nil {
+		return nil, fmt.Errorf("key index: %w: %v", ErrIndexStale, db.keysStale)
+	}
+	var keys []string
+	var ferr error
+	err := db.keys.scan(prefix, func(key string, idx uint32) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		var live bool
+		if live, ferr = db.liveAt(idx); ferr != nil {
+			return false
+		}
+		if live {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	if err == nil {
+		err = ferr
+	}
+	return keys, err
+}
+
+// liveAt reports whether the record the key index places at slot idx has not
+// expired. Expired records stay indexed until they are reclaimed.
+func (db *DB) liveAt(idx uint32) (bool, error) {
+	if !db.hasTTL {
+		return true, nil
+	}
+	s, err := db.readSlot(int(idx))
+	if err != nil {
+		return false, err
+	}
+	return !s.expired(), nil
+}
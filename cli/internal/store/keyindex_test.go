@@ -0,0 +1,132 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func wantRange(t *testing.T, db *DB, from, to string, limit int, want []string) {
+	t.Helper()
+	got, err := db.Range(from, to, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Range(%q, %q, %d) = %q, want %q", from, to, limit, got, want)
+	}
+}
+
+func TestKeyIndexRange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.bin")
+	opts := Options{KeyIndex: true, ReapInterval: -1}
+	// Small enough that the inserts resize the table, which rebuilds the tree,
+	// and enough keys to split its nodes.
+	db, err := Create(path, CreateOptions{Slots: 31, Options: opts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for i := 0; i < 1500; i++ {
+		keys = append(keys, fmt.Sprintf("k%04d", i))
+	}
+	for i := range keys {
+		// Insert out of order.
+		key := keys[(i*7)%len(keys)]
+		if err := db.Insert(key, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < len(keys); i += 10 {
+		if _, err := db.Delete(keys[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	live := slices.DeleteFunc(slices.Clone(keys), func(k string) bool { return k[len(k)-1] == '0' })
+
+	// Both bounds are inclusive, and they need not be stored keys.
+	wantRange(t, db, "k0011", "k0015", 0, []string{"k0011", "k0012", "k0013", "k0014", "k0015"})
+	wantRange(t, db, "k0010", "k0012", 0, []string{"k0011", "k0012"})
+	wantRange(t, db, "k00105", "k0012", 0, []string{"k0011", "k0012"})
+	wantRange(t, db, "k1498", "", 0, []string{"k1498", "k1499"})
+	wantRange(t, db, "k1499\x00", "", 0, nil)
+	wantRange(t, db, "k0012", "k0011", 0, nil)
+	wantRange(t, db, "", "", 0, live)
+
+	// Paging with the documented "\x00" suffix walks every key once.
+	var paged []string
+	for from := ""; ; {
+		page, err := db.Range(from, "", 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		paged = append(paged, page...)
+		from = page[len(page)-1] + "\x00"
+	}
+	if !slices.Equal(paged, live) {
+		t.Fatalf("paged through %d keys, want %d", len(paged), len(live))
+	}
+
+	got, err := db.Prefix("k012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := live[slices.Index(live, "k0121") : slices.Index(live, "k0129")+1]; !slices.Equal(got, want) {
+		t.Fatalf("Prefix(k012) = %q, want %q", got, want)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(path, OpenOptions{Options: opts}); err != nil {
+		t.Fatal(err)
+	}
+	wantRange(t, db, "k0289", "k0291", 0, []string{"k0289", "k0291"})
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if db, err = Open(path, OpenOptions{Options: Options{ReapInterval: -1}}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Range("", "", 0); !errors.Is(err, ErrNoKeyIndex) {
+		t.Fatalf("Range without the key index: %v", err)
+	}
+}
+
+func TestKeyIndexStaleAfterFailedUpdate(t *testing.T) {
+	opts := Options{KeyIndex: true, ReapInterval: -1}
+	db := createTestDB(t, CreateOptions{Slots: 61, Options: opts})
+	if err := db.Insert("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	// The tree file going away fails its update only after the batch commits.
+	if err := db.keys.f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("b", 2); err != nil {
+		t.Fatalf("Insert with a failing key index: %v", err)
+	}
+	if _, err := db.Range("", "", 0); !errors.Is(err, ErrIndexStale) {
+		t.Fatalf("Range on a stale key index: %v", err)
+	}
+	if _, err := db.Prefix(""); !errors.Is(err, ErrIndexStale) {
+		t.Fatalf("Prefix on a stale key index: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(db.path, OpenOptions{Options: opts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	wantRange(t, db, "", "", 0, []string{"a", "b"})
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Files written before the header page existed are a bare array of SlotSize
+// slots, each
+//
+//	state(uint8) | hash(uint32) | len(uint16) | JSON envelope
+//
+// placed with FNV-1a and linear probing over closestPrime(slots) slots. Open
+// converts such a file when it opens it for writing: it builds a new file with
+// the same slot count and the same layout next to it, inserts every live record
+// and renames it over the old one, which is never written to. A crash before the
+// rename leaves the old file as it was, and the next Open starts over. A file is
+// only taken for a legacy one if every slot decodes; anything else is still
+// rejected with ErrBadMagic.
+const (
+	legacyHeaderSize = 1 + 4 + 2
+	legacyPayloadCap = SlotSize - legacyHeaderSize
+
+	legacySuffix = ".legacy"
+)
+
+// legacyFile is the content of a legacy file.
+type legacyFile struct {
+	slots   int
+	records []*envelope
+}
+
+// readLegacy returns the content of f if it is a legacy file, and nil otherwise.
+func readLegacy(f *os.File) (*legacyFile, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() == 0 || st.Size()%SlotSize != 0 {
+		return nil, nil
+	}
+	var head [len(magic)]byte
+	if _, err := f.ReadAt(head[:], 0); err != nil {
+		return nil, err
+	}
+	if head == magic {
+		return nil, nil
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, st.Size()))
+	if err != nil {
+		return nil, err
+	}
+	lf := &legacyFile{slots: len(data) / SlotSize}
+	for off := 0; off < len(data); off += SlotSize {
+		buf := data[off : off+SlotSize]
+		plen := int(binary.LittleEndian.Uint16(buf[5:7]))
+		if buf[0] > StateDeleted || plen > legacyPayloadCap {
+			return nil, nil
+		}
+		if buf[0] != StateOcc {
+			continue
+		}
+		payload := buf[legacyHeaderSize : legacyHeaderSize+plen]
+		if len(payload) == 0 || payload[0] != '{' {
+			return nil, nil
+		}
+		env, err := decodeEnvelope(payload)
+		if err != nil || binary.LittleEndian.Uint32(buf[1:5]) != (fnvHasher{}).Hash(env.Key) {
+			return nil, nil
+		}
+		lf.records = append(lf.records, env)
+	}
+	return lf, nil
+}
+
+// convertLegacy rebuilds the legacy file at path, holding lf, in the current
+// format and returns the new file, locked and renamed into place. Caller holds
+// the lock of the DB.
+func convertLegacy(path string, lf *legacyFile) (*os.File, error) {
+	tmp := path + legacySuffix
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	// The legacy layout never fails an insert and keeps the slot count, which
+	// callers of the old Open passed in and may check with OpenOptions.Slots.
+	db, err := Create(tmp, CreateOptions{
+		Slots:   lf.slots,
+		Hash:    HashFNV1a,
+		Probe:   ProbeLinear,
+		Options: Options{MaxLoadFactor: -1, ReapInterval: -1},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, env := range lf.records {
+		payload := encodeEnvelope(env)
+		hk := db.hashKey(env.Key)
+		if err = db.write(func() error { return db.insert(env.Key, hk, 0, payload) }); err != nil {
+			err = fmt.Errorf("%s: %w", env.Key, err)
+			break
+		}
+	}
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	for _, side := range []string{walSuffix, lockSuffix} {
+		if rerr := os.Remove(tmp + side); err == nil && !errors.Is(rerr, os.ErrNotExist) {
+			err = rerr
+		}
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	f, err := os.OpenFile(tmp, os.O_RDWR, 0)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	if err := lockFile(f, path, false, 0); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	if err := syncDir(path); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
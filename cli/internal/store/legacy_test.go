@@ -0,0 +1,91 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testdata/legacy.bin was written by the store before the header page existed:
+// 31 slots holding client:1 (models.Client), count, long and tags, plus the
+// tombstone of gone.
+func copyLegacy(t *testing.T) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "legacy.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "db.bin")
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenConvertsLegacyFile(t *testing.T) {
+	path := copyLegacy(t)
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only a writer converts the file.
+	if _, err := Open(path, OpenOptions{ReadOnly: true}); !errors.Is(err, ErrNeedsRecovery) {
+		t.Fatalf("read-only Open of a legacy file: %v", err)
+	}
+	if after, err := os.ReadFile(path); err != nil || !bytes.Equal(after, before) {
+		t.Fatalf("read-only Open changed the file: %v", err)
+	}
+	// Left behind by a conversion that crashed.
+	if err := os.WriteFile(path+legacySuffix, []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(path, OpenOptions{Slots: 31, Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := db.Header(); h.Version != FormatVersion || h.Slots != 31 || h.Hash != HashFNV1a {
+		t.Fatalf("converted header %+v", h)
+	}
+	var count int
+	if found, err := db.Select("count", &count); err != nil || !found || count != 42 {
+		t.Fatalf("count: found=%v value=%d err=%v", found, count, err)
+	}
+	wantValues(t, db, map[string]string{"long": strings.Repeat("x", 460)})
+	var client struct{ Name, Email string }
+	if found, err := db.Select("client:1", &client); err != nil || !found || client.Name != "Acme" || client.Email != "acme@example.com" {
+		t.Fatalf("client:1: found=%v value=%+v err=%v", found, client, err)
+	}
+	idx, _, _, err := db.find("client:1", db.hashKey("client:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, err := db.SlotDetail(idx); err != nil || d.Type != "models.Client" {
+		t.Fatalf("client:1 stored as %q: %v", d.Type, err)
+	}
+	if found, err := db.Select("gone", new(string)); err != nil || found {
+		t.Fatalf("deleted record: found=%v, %v", found, err)
+	}
+	if st, err := db.Stats(); err != nil || st.Occupied != 4 || st.Deleted != 0 {
+		t.Fatalf("Stats: %d occupied, %d deleted, %v", st.Occupied, st.Deleted, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, side := range []string{legacySuffix, legacySuffix + walSuffix, legacySuffix + lockSuffix} {
+		if _, err := os.Stat(path + side); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%s left behind: %v", side, err)
+		}
+	}
+
+	// The converted file opens like any other.
+	db, err = Open(path, OpenOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	wantValues(t, db, map[string]string{"long": strings.Repeat("x", 460)})
+}
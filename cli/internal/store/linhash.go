@@ -0,0 +1,181 @@
+package store
+
+import (
+	"iter"
+	"math/bits"
+)
+
+// Linear hashing grows the table one slot at a time instead of rehashing all of
+// it. With m = BaseSlots<<level the largest such value not above the slot count,
+// and next = slots-m the split pointer, a key's home slot is hash % m, or
+// hash % 2m for keys whose hash % m falls below next. Adding a slot therefore
+// only moves keys out of slot next (into the new slot m+next); collisions are
+// resolved by linear probing, so the probe runs touched by that change are
+// re-placed as part of the split.
+//
+// Slots not yet split in the current round take twice the keys of the others, so
+// splits start at half of MaxLoadFactor: that keeps the unsplit slots, which reach
+// twice the average load right before the round ends, within MaxLoadFactor.
+//
+// Slots past BaseSlots cannot go after the base slots, where the overflow region
+// starts, so they live in segments allocated from the overflow region: segment k
+// holds slots [BaseSlots<<k, BaseSlots<<(k+1)) and is reserved as a whole, by
+// extending the file, when the table first grows into it. Nothing already stored
+// ever moves to make room.
+type linHashProber struct {
+	slots int
+	m     int
+	next  int
+}
+
+func newLinHashProber(slots, base int) linHashProber {
+	m := base
+	for m*2 <= slots {
+		m *= 2
+	}
+	return linHashProber{slots: slots, m: m, next: slots - m}
+}
+
+func (p linHashProber) home(hk uint32) int {
+	a := int(uint64(hk) % uint64(p.m))
+	if a < p.next {
+		a = int(uint64(hk) % uint64(2*p.m))
+	}
+	return a
+}
+
+func (p linHashProber) Probe(hk uint32) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		start := p.home(hk)
+		for i := 0; i < p.slots; i++ {
+			if !yield((start + i) % p.slots) {
+				return
+			}
+		}
+	}
+}
+
+func (p linHashProber) Distance(hk uint32, idx int) int {
+	return (idx - p.home(hk) + p.slots) % p.slots
+}
+
+// slotOffset returns the file offset of slot index.
+func (db *DB) slotOffset(index int) int64 {
+	base := int(db.hdr.BaseSlots)
+	if db.hdr.Probe != ProbeLinearHashing || index < base {
+		return HeaderPageSize + int64(index)*SlotSize
+	}
+	k := bits.Len(uint(index/base)) - 1
+	return db.ovfOffset(db.hdr.Segments[k]) + int64(index-base<<k)*SlotSize
+}
+
+// growLinear splits slots until extra more entries fit under the split threshold
+// (see maxLoad), always at least once, as one batch. Caller holds db.wmu.
+func (db *DB) growLinear(extra int) error {
+	return db.write(func() error {
+		for {
+			if err := db.split(); err != nil {
+				return err
+			}
+			if float64(db.used+db.deleted+extra)/float64(db.slots) <= db.maxLoad() {
+				return nil
+			}
+		}
+	})
+}
+
+// split adds one slot to a linear hashing table and re-places the entries of the
+// probe runs it affects: the run from the split slot on, whose keys may now hash
+// to the new slot, and the run wrapping around to slot 0, which the new last slot
+// now interrupts. Tombstones in those runs are dropped. Caller runs it inside db.write.
+func (db *DB) split() error {
+	old := db.slots
+	base := int(db.hdr.BaseSlots)
+	if uint64(old) >= uint64(base)<<maxSegments {
+		return ErrTableFull
+	}
+	splitAt := db.probe.(linHashProber).next
+	if old%base == 0 && bits.OnesCount(uint(old/base)) == 1 {
+		// First slot of a new segment: reserve all of it.
+		k := bits.Len(uint(old/base)) - 1
+		db.hdr.Segments[k] = db.hdr.OverflowPages + 1
+		db.hdr.OverflowPages += uint32(old)
+		db.batch.growFile(db.hdr.fileSize())
+	}
+
+	// Collect the runs in the old layout before it changes.
+	var run []int
+	var moved []slot
+	seen := make(map[int]bool)
+	collect := func(from int) error {
+		for i := from; !seen[i]; i = (i + 1) % old {
+			s, err := db.readSlot(i)
+			if err != nil {
+				return err
+			}
+			if s.state == StateEmpty {
+				return nil
+			}
+			seen[i] = true
+			run = append(run, i)
+			if s.state == StateOcc {
+				moved = append(moved, s)
+			}
+		}
+		return nil
+	}
+	if err := collect(splitAt); err != nil {
+		return err
+	}
+	last, err := db.readSlot(old - 1)
+	if err != nil {
+		return err
+	}
+	if last.state != StateEmpty {
+		if err := collect(0); err != nil {
+			return err
+		}
+	}
+	for _, i := range run {
+		if err := db.writeSlot(i, slot{state: StateEmpty}); err != nil {
+			return err
+		}
+	}
+	db.used -= len(moved)
+	db.deleted -= len(run) - len(moved)
+
+	db.hdr.Slots++
+	db.slots++
+	db.probe = newProber(&db.hdr)
+	if err := db.writeSlot(old, slot{state: StateEmpty}); err != nil {
+		return err
+	}
+	for _, s := range moved {
+		if err := db.reinsert(s); err != nil {
+			return err
+		}
+	}
+	return db.writeHeader()
+}
+
+// reinsert writes an existing record into the first free slot of its probe run.
+func (db *DB) reinsert(s slot) error {
+	for idx := range db.probe.Probe(s.hash) {
+		cur, err := db.readSlot(idx)
+		if err != nil {
+			return err
+		}
+		if cur.state == StateOcc {
+			continue
+		}
+		if err := db.writeSlot(idx, s); err != nil {
+			return err
+		}
+		db.used++
+		if cur.state == StateDeleted {
+			db.deleted--
+		}
+		return nil
+	}
+	return ErrTableFull
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// Deleting and inserting at a constant live count must not keep splitting the
+// table: once tombstones make up most of the load it is rebuilt instead.
+func TestLinearHashingChurnStaysBounded(t *testing.T) {
+	db, err := Create(filepath.Join(t.TempDir(), "db.bin"), CreateOptions{
+		Slots:   16,
+		Probe:   ProbeLinearHashing,
+		Options: Options{ReapInterval: -1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const live = 20
+	for i := 0; i < live; i++ {
+		if err := db.Insert(fmt.Sprintf("k%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Splits start at half of MaxLoadFactor and tombstones trigger a rebuild
+	// before they outnumber the live records, so the table stays under
+	// 2*live / (DefaultMaxLoadFactor/2) slots.
+	const bound = 107
+	for i := live; i < 5000; i++ {
+		if _, err := db.Delete(fmt.Sprintf("k%d", i-live)); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Insert(fmt.Sprintf("k%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+		if db.slots > bound {
+			t.Fatalf("after %d deletes the table has %d slots for %d records", i-live+1, db.slots, live)
+		}
+	}
+	for i := 5000 - live; i < 5000; i++ {
+		var v int
+		if found, err := db.Select(fmt.Sprintf("k%d", i), &v); err != nil || !found || v != i {
+			t.Fatalf("k%d: found=%v value=%d err=%v", i, found, v, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// The mutexes in DB only keep the goroutines of one process apart, so Open and
+// Create also take an advisory lock on <db>.lock: exclusive for a writer, shared
+// for Options.ReadOnly. The lock lives in a file of its own because a resize
+// renames a new DB file into place, which would drop a lock held on the old one.
+// A shared lock never creates <db>.lock: when it is missing, as on a read-only
+// mount, the DB file itself is locked instead. So that such a reader and a writer
+// still exclude each other, a writer also holds an exclusive lock on the DB file,
+// taken again on every file renamed into its place. Both are released on Close.
+// Where flock is not available no lock is taken.
+const lockSuffix = ".lock"
+
+// lockPoll is how often a locked DB is retried while waiting for it.
+const lockPoll = 10 * time.Millisecond
+
+var (
+	ErrLocked   = errors.New("database locked by another process")
+	ErrReadOnly = errors.New("database opened read-only")
+	// ErrNeedsRecovery means a read-only open found work left by a writer that did
+	// not close cleanly, such as an unreplayed WAL, which only a writer can redo.
+	ErrNeedsRecovery = errors.New("database needs recovery; open it for writing once")
+)
+
+// lockDB takes the lock of the DB at path, shared or exclusive, retrying for up
+// to timeout while another process holds it. Closing the returned file releases it.
+func lockDB(path string, shared bool, timeout time.Duration) (*os.File, error) {
+	var f *os.File
+	var err error
+	if shared {
+		f, err = os.Open(path + lockSuffix)
+		if errors.Is(err, os.ErrNotExist) {
+			f, err = os.Open(path)
+		}
+	} else {
+		f, err = os.OpenFile(path+lockSuffix, os.O_RDONLY|os.O_CREATE, 0o644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f, path, shared, timeout); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// lockFile places a lock on f, the lock file or the DB file of the DB at path,
+// retrying for up to timeout while another process holds it.
+func lockFile(f *os.File, path string, shared bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := tryLock(f, shared)
+		if err != nil {
+			return fmt.Errorf("lock: %w", err)
+		}
+		if ok {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%w: %s", ErrLocked, path)
+		}
+		time.Sleep(lockPoll)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package store
+
+import "os"
+
+func tryLock(f *os.File, shared bool) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,101 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package store
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// wantLocked fails unless opening the DB at path with opts fails with ErrLocked.
+func wantLocked(t *testing.T, path string, opts OpenOptions) {
+	t.Helper()
+	opts.ReapInterval = -1
+	db, err := Open(path, opts)
+	if err == nil {
+		_ = db.Close()
+	}
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Open(ReadOnly: %v) = %v, want ErrLocked", opts.ReadOnly, err)
+	}
+}
+
+func openTestDB(t *testing.T, path string, opts OpenOptions) *DB {
+	t.Helper()
+	opts.ReapInterval = -1
+	db, err := Open(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestLockWriterExcludesEveryone(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	wantLocked(t, db.path, OpenOptions{})
+	wantLocked(t, db.path, OpenOptions{ReadOnly: true})
+
+	// A waiting Open gets the lock once the holder closes.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = db.Close()
+	}()
+	openTestDB(t, db.path, OpenOptions{Options: Options{LockTimeout: 5 * time.Second}})
+}
+
+func TestLockReadOnlyShares(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	if err := db.Insert("a", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ro := OpenOptions{ReadOnly: true}
+	r1 := openTestDB(t, db.path, ro)
+	r2 := openTestDB(t, db.path, ro)
+	wantLocked(t, db.path, OpenOptions{})
+	if err := r1.Insert("b", "b"); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Insert on a read-only DB: %v", err)
+	}
+	if err := Restore(db.path, db.path, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Restore under a reader: %v", err)
+	}
+	var v string
+	if found, err := r2.Select("a", &v); err != nil || !found {
+		t.Fatalf("Select on a read-only DB: found=%v, %v", found, err)
+	}
+}
+
+func TestLockWithoutLockFile(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// A reader that finds no lock file, as on a read-only mount, still keeps
+	// writers out.
+	if err := os.Remove(db.path + lockSuffix); err != nil {
+		t.Fatal(err)
+	}
+	r := openTestDB(t, db.path, OpenOptions{ReadOnly: true})
+	wantLocked(t, db.path, OpenOptions{})
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// And a writer keeps it out, also after a resize replaced the DB file.
+	w := openTestDB(t, db.path, OpenOptions{})
+	w.wmu.Lock()
+	err := w.resize(0)
+	w.wmu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(db.path + lockSuffix); err != nil {
+		t.Fatal(err)
+	}
+	wantLocked(t, db.path, OpenOptions{ReadOnly: true})
+}
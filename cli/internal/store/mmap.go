@@ -0,0 +1,70 @@
+package store
+
+import "fmt"
+
+// With Options.Mmap the DB file is mapped into memory: slots are read straight
+// from the mapping, without a syscall or a buffer per read, and committed pages
+// are copied into it and flushed with msync (asynchronously after each batch,
+// synchronously on checkpoint). The WAL is unchanged, so durability is the same
+// as with pread/pwrite. Whenever a batch changes the file size the mapping is
+// dropped, the file resized and mapped again; a resize or vacuum maps the new file.
+// On platforms without mmap the option is ignored.
+
+// remap maps the whole DB file, replacing any previous mapping.
+// Caller holds db.mu exclusively or is the only user of db.
+func (db *DB) remap() error {
+	if err := db.unmap(); err != nil {
+		return err
+	}
+	st, err := db.f.Stat()
+	if err != nil {
+		return err
+	}
+	mm, err := mmapFile(db.f, int(st.Size()), db.readOnly)
+	if err != nil {
+		return fmt.Errorf("mmap: %w", err)
+	}
+	db.mm = mm
+	return nil
+}
+
+// unmap drops the mapping, if any.
+func (db *DB) unmap() error {
+	if db.mm == nil {
+		return nil
+	}
+	mm := db.mm
+	db.mm = nil
+	if err := munmap(mm); err != nil {
+		return fmt.Errorf("munmap: %w", err)
+	}
+	return nil
+}
+
+// applyMapped is applyBatch for a mapped file.
+func (db *DB) applyMapped(b *batch) error {
+	need := int64(len(db.mm))
+	if b.reset >= 0 {
+		need = b.reset
+	}
+	for _, off := range b.order {
+		need = max(need, off+int64(len(b.pages[off])))
+	}
+	if b.reset >= 0 || b.grow > 0 || need > int64(len(db.mm)) {
+		// Pages past the end of the file cannot be written through the mapping,
+		// and shrinking the file under it would fault on access.
+		if err := db.unmap(); err != nil {
+			return err
+		}
+		if err := applyBatch(db.f, &batch{reset: b.reset, grow: max(b.grow, need)}); err != nil {
+			return err
+		}
+		if err := db.remap(); err != nil {
+			return err
+		}
+	}
+	for _, off := range b.order {
+		copy(db.mm[off:], b.pages[off])
+	}
+	return msync(db.mm, false)
+}
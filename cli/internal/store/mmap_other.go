@@ -0,0 +1,22 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package store
+
+import (
+	"errors"
+	"os"
+)
+
+const mmapSupported = false
+
+func mmapFile(f *os.File, size int, readOnly bool) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func munmap(b []byte) error {
+	return errors.ErrUnsupported
+}
+
+func msync(b []byte, wait bool) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// wantMapped fails unless db maps its whole file.
+func wantMapped(t *testing.T, db *DB) {
+	t.Helper()
+	st, err := db.f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.mm == nil || int64(len(db.mm)) != st.Size() {
+		t.Fatalf("mapping of %d bytes over a file of %d", len(db.mm), st.Size())
+	}
+}
+
+func TestMmap(t *testing.T) {
+	if !mmapSupported {
+		t.Skip("mmap is not supported on this platform")
+	}
+	db := createTestDB(t, CreateOptions{Slots: 31, Options: Options{Mmap: true}})
+	wantMapped(t, db)
+	want := map[string]string{"a": "a"}
+	if err := db.Insert("a", "a"); err != nil {
+		t.Fatal(err)
+	}
+	// The write went through the mapping, which shares the file's pages.
+	idx, _, _, err := db.find("a", db.hashKey("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := db.slotOffset(idx)
+	page := make([]byte, SlotSize)
+	if _, err := db.f.ReadAt(page, off); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(page, db.mm[off:off+SlotSize]) {
+		t.Fatal("the mapping and the file disagree")
+	}
+
+	// A value spilling into overflow pages grows the file and the mapping.
+	want["big"] = strings.Repeat("x", 5*SlotSize)
+	if err := db.Insert("big", want["big"]); err != nil {
+		t.Fatal(err)
+	}
+	wantMapped(t, db)
+	// So does a resize, which maps the new file.
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("k%d", i)
+		want[key] = key
+		if err := db.Insert(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st, err := db.Stats(); err != nil || st.Resizes == 0 {
+		t.Fatalf("Stats: %d resizes, %v", st.Resizes, err)
+	}
+	wantMapped(t, db)
+	wantValues(t, db, want)
+
+	// Clear shrinks the file under the mapping.
+	if err := db.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	wantMapped(t, db)
+	want = map[string]string{"after": "clear"}
+	if err := db.Insert("after", "clear"); err != nil {
+		t.Fatal(err)
+	}
+	wantValues(t, db, want)
+	if found, err := db.Select("a", new(string)); err != nil || found {
+		t.Fatalf("Select after Clear: found=%v, %v", found, err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, mmap := range []bool{true, false} {
+		db, err := Open(db.path, OpenOptions{Options: Options{Mmap: mmap, ReapInterval: -1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantValues(t, db, want)
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package store
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const mmapSupported = true
+
+// mmapFile maps size bytes of f shared, read-write unless readOnly is set.
+func mmapFile(f *os.File, size int, readOnly bool) ([]byte, error) {
+	prot := syscall.PROT_READ | syscall.PROT_WRITE
+	if readOnly {
+		prot = syscall.PROT_READ
+	}
+	return syscall.Mmap(int(f.Fd()), 0, size, prot, syscall.MAP_SHARED)
+}
+
+func munmap(b []byte) error {
+	return syscall.Munmap(b)
+}
+
+// msync writes dirty pages of the mapping back to the file, waiting for it when
+// wait is set and only scheduling it otherwise.
+func msync(b []byte, wait bool) error {
+	flags := syscall.MS_ASYNC
+	if wait {
+		flags = syscall.MS_SYNC
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), uintptr(flags))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Payloads that do not fit in a slot spill into a chain of overflow pages stored
+// after the slot table. Such a slot has flagOverflow set and its data starts with
+// a pointer:
+//
+//	head(uint32, first overflow page) | total(uint32, full payload length) | first chunk
+//
+// Each overflow page is SlotSize bytes:
+//
+//	next(uint32, 0 = end of chain) | len(uint16) | crc32c(uint32, of next+len+chunk) | chunk
+//
+// Page numbers are 1-based so that 0 can mean "none". Freed pages are linked
+// through next into the free list whose head is kept in the file header.
+const (
+	flagOverflow = 1 << 0
+
+	ovfPtrSize    = 4 + 4
+	ovfPageHeader = 4 + 2 + 4
+	ovfPageCap    = SlotSize - ovfPageHeader
+
+	// MaxPayloadSize bounds the encoded size of a single record.
+	MaxPayloadSize = 1 << 20
+)
+
+// errBadPage marks overflow page contents that fail validation, as opposed to I/O errors.
+var errBadPage = errors.New("bad overflow page")
+
+func (db *DB) ovfOffset(page uint32) int64 {
+	return HeaderPageSize + (int64(db.hdr.inlineSlots())+int64(page)-1)*SlotSize
+}
+
+func (db *DB) readOverflowPage(page uint32) (next uint32, chunk []byte, err error) {
+	if page == 0 || page > db.hdr.OverflowPages {
+		return 0, nil, fmt.Errorf("%w %d: out of range", errBadPage, page)
+	}
+	buf := make([]byte, SlotSize)
+	if err := db.readAt(buf, db.ovfOffset(page)); err != nil {
+		return 0, nil, err
+	}
+	next = binary.LittleEndian.Uint32(buf[0:4])
+	n := int(binary.LittleEndian.Uint16(buf[4:6]))
+	if n > ovfPageCap {
+		return 0, nil, fmt.Errorf("%w %d: bad chunk length", errBadPage, page)
+	}
+	chunk = buf[ovfPageHeader : ovfPageHeader+n]
+	if binary.LittleEndian.Uint32(buf[6:10]) != slotChecksum(buf[:6], chunk) {
+		return 0, nil, fmt.Errorf("%w %d: checksum mismatch", errBadPage, page)
+	}
+	return next, chunk, nil
+}
+
+func (db *DB) writeOverflowPage(page, next uint32, chunk []byte) error {
+	buf := make([]byte, SlotSize)
+	binary.LittleEndian.PutUint32(buf[0:4], next)
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(len(chunk)))
+	copy(buf[ovfPageHeader:], chunk)
+	binary.LittleEndian.PutUint32(buf[6:10], slotChecksum(buf[:6], chunk))
+	return db.writeAt(buf, db.ovfOffset(page))
+}
+
+// payload returns the full payload of an occupied slot, reassembling its overflow
+// chain and decompressing it. A broken chain is reported as *ErrCorruptSlot for
+// the owning slot.
+func (db *DB) payload(s slot) ([]byte, error) {
+	if s.flags&flagOverflow == 0 {
+		return inflate(s, s.data)
+	}
+	corrupt := func(reason string) error {
+		return &ErrCorruptSlot{Index: s.index, Hash: s.hash, Reason: reason}
+	}
+	if len(s.data) < ovfPtrSize {
+		return nil, corrupt("bad overflow pointer")
+	}
+	page := binary.LittleEndian.Uint32(s.data[0:4])
+	total := int(binary.LittleEndian.Uint32(s.data[4:8]))
+	if total > MaxPayloadSize {
+		return nil, corrupt(fmt.Sprintf("bad overflow length %d", total))
+	}
+	out := make([]byte, 0, total)
+	out = append(out, s.data[ovfPtrSize:]...)
+	for page != 0 && len(out) < total {
+		next, chunk, err := db.readOverflowPage(page)
+		if errors.Is(err, errBadPage) {
+			return nil, corrupt(err.Error())
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, chunk...)
+		page = next
+	}
+	if len(out) != total {
+		return nil, corrupt(fmt.Sprintf("overflow chain length %d, want %d", len(out), total))
+	}
+	return inflate(s, out)
+}
+
+// writeRecord stores payload as an occupied slot at index, compressed if the DB
+// asks for it, spilling whatever does not fit into newly allocated overflow pages.
+func (db *DB) writeRecord(index int, hk, version uint32, expires int64, payload []byte) error {
+	s := slot{state: StateOcc, hash: hk, version: version, expires: expires}
+	db.hdr.LastVersion = max(db.hdr.LastVersion, version)
+	if db.hdr.Compression == CompressDeflate {
+		if c, ok := compressPayload(payload); ok {
+			payload = c
+			s.flags |= flagCompressed
+		}
+	}
+	if len(payload) <= PayloadCap {
+		s.data = payload
+		return db.writeSlot(index, s)
+	}
+	inline := PayloadCap - ovfPtrSize
+	rest := payload[inline:]
+	pages := make([]uint32, 0, (len(rest)+ovfPageCap-1)/ovfPageCap)
+	for range cap(pages) {
+		p, err := db.allocOverflow()
+		if err != nil {
+			return err
+		}
+		pages = append(pages, p)
+	}
+	for i, p := range pages {
+		var next uint32
+		if i+1 < len(pages) {
+			next = pages[i+1]
+		}
+		chunk := rest[i*ovfPageCap : min((i+1)*ovfPageCap, len(rest))]
+		if err := db.writeOverflowPage(p, next, chunk); err != nil {
+			return err
+		}
+	}
+	if err := db.writeHeader(); err != nil {
+		return err
+	}
+	s.flags |= flagOverflow
+	s.data = make([]byte, PayloadCap)
+	binary.LittleEndian.PutUint32(s.data[0:4], pages[0])
+	binary.LittleEndian.PutUint32(s.data[4:8], uint32(len(payload)))
+	copy(s.data[ovfPtrSize:], payload[:inline])
+	return db.writeSlot(index, s)
+}
+
+// allocOverflow takes a page from the free list or extends the overflow region.
+// The caller persists the header once the whole chain is allocated.
+func (db *DB) allocOverflow() (uint32, error) {
+	if page := db.hdr.OverflowFreeHead; page != 0 {
+		next, _, err := db.readOverflowPage(page)
+		if err != nil {
+			return 0, err
+		}
+		db.hdr.OverflowFreeHead = next
+		db.hdr.OverflowFree--
+		return page, nil
+	}
+	db.hdr.OverflowPages++
+	return db.hdr.OverflowPages, nil
+}
+
+// freeOverflow returns the overflow chain of s, if any, to the free list.
+func (db *DB) freeOverflow(s slot) error {
+	if s.flags&flagOverflow == 0 || len(s.data) < ovfPtrSize {
+		return nil
+	}
+	page := binary.LittleEndian.Uint32(s.data[0:4])
+	for page != 0 {
+		next, _, err := db.readOverflowPage(page)
+		if err != nil {
+			return err
+		}
+		if err := db.writeOverflowPage(page, db.hdr.OverflowFreeHead, nil); err != nil {
+			return err
+		}
+		db.hdr.OverflowFreeHead = page
+		db.hdr.OverflowFree++
+		page = next
+	}
+	return db.writeHeader()
+}
+
+func (db *DB) writeHeader() error {
+	return db.writeAt(db.hdr.encode(), 0)
+}
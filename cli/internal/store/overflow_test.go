@@ -0,0 +1,85 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func overflowPages(t *testing.T, db *DB) (pages, free int) {
+	t.Helper()
+	st, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.OverflowPages, st.OverflowFree
+}
+
+func TestOverflowChains(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	big := strings.Repeat("x", 5*SlotSize)
+	want := map[string]string{"big": big, "small": "small"}
+	for key, v := range want {
+		if err := db.Insert(key, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if slotFlags(t, db, "big")&flagOverflow == 0 || slotFlags(t, db, "small")&flagOverflow != 0 {
+		t.Fatal("overflow flags do not follow the payload sizes")
+	}
+	pages, free := overflowPages(t, db)
+	if pages < 5 || free != 0 {
+		t.Fatalf("%d overflow pages, %d free", pages, free)
+	}
+	wantValues(t, db, want)
+
+	// Deleting the record puts its chain on the free list, and the next long
+	// payload takes its pages from there instead of growing the file.
+	if _, err := db.Delete("big"); err != nil {
+		t.Fatal(err)
+	}
+	delete(want, "big")
+	if p, f := overflowPages(t, db); p != pages || f != pages {
+		t.Fatalf("after Delete: %d overflow pages, %d free, want %d of %d", p, f, pages, pages)
+	}
+	want["again"] = big
+	if err := db.Insert("again", big); err != nil {
+		t.Fatal(err)
+	}
+	if p, f := overflowPages(t, db); p != pages || f != 0 {
+		t.Fatalf("after reuse: %d overflow pages, %d free, want 0 of %d", p, f, pages)
+	}
+
+	// Shrinking a value frees its chain; growing it past that takes new pages.
+	if err := db.Update("again", "short"); err != nil {
+		t.Fatal(err)
+	}
+	if _, f := overflowPages(t, db); f != pages {
+		t.Fatalf("after shrinking: %d free, want %d", f, pages)
+	}
+	want["again"] = big + big
+	if err := db.Update("again", want["again"]); err != nil {
+		t.Fatal(err)
+	}
+	if p, f := overflowPages(t, db); p <= pages || f != 0 {
+		t.Fatalf("after growing: %d overflow pages, %d free", p, f)
+	}
+	wantValues(t, db, want)
+
+	if err := db.Insert("huge", strings.Repeat("x", MaxPayloadSize)); !errors.Is(err, ErrPayloadTooBig) {
+		t.Fatalf("Insert over MaxPayloadSize: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(db.path, OpenOptions{Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	wantValues(t, db, want)
+	if rep, err := db.Verify(); err != nil || len(rep.Corrupt) != 0 {
+		t.Fatalf("Verify: %+v, %v", rep, err)
+	}
+}
@@ -0,0 +1,179 @@
+package store
+
+import "iter"
+
+// Prober is a collision resolution strategy: it decides which slots a key hash
+// visits, and in which order, on insert and lookup. Every strategy starts at the
+// home slot hash % modPrime (folded into the slot count).
+type Prober interface {
+	// Probe yields the slots to try for hk, home slot first. Except with cuckoo
+	// hashing the sequence reaches every slot of the table, though it may visit
+	// some more than once.
+	Probe(hk uint32) iter.Seq[int]
+	// Distance returns how many steps into the probe sequence of hk slot idx lies
+	// (0 for the home slot).
+	Distance(hk uint32, idx int) int
+}
+
+// newProber returns the Prober for the strategy and table size recorded in h.
+func newProber(h *Header) Prober {
+	t := table{slots: int(h.Slots), modPrime: h.ModPrime}
+	switch h.Probe {
+	case ProbeQuadratic:
+		return quadraticProber{t}
+	case ProbeDouble:
+		return doubleProber{t}
+	case ProbeRobinHood:
+		return robinHoodProber{linearProber{t}}
+	case ProbeCuckoo:
+		return newCuckooProber(t)
+	case ProbeLinearHashing:
+		return newLinHashProber(int(h.Slots), int(h.BaseSlots))
+	}
+	return linearProber{t}
+}
+
+// place lays entry e out in pos the way an insert with p would, given that no
+// other entry has the same key. pos holds 1 + the index in hashes of the entry
+// placed in each slot, 0 for a free slot. Reports false if e (or an entry it
+// displaced) found no free slot.
+func place(p Prober, pos []int, hashes []uint32, e int) bool {
+	switch p := p.(type) {
+	case cuckooProber:
+		return p.place(pos, hashes, e)
+	case robinHoodProber:
+		dist := 0
+		for idx := range p.Probe(hashes[e]) {
+			if pos[idx] == 0 {
+				pos[idx] = e + 1
+				return true
+			}
+			o := pos[idx] - 1
+			if d := p.Distance(hashes[o], idx); d < dist {
+				pos[idx] = e + 1
+				e, dist = o, d
+			}
+			dist++
+		}
+		return false
+	}
+	for idx := range p.Probe(hashes[e]) {
+		if pos[idx] == 0 {
+			pos[idx] = e + 1
+			return true
+		}
+	}
+	return false
+}
+
+type table struct {
+	slots    int
+	modPrime uint32
+}
+
+func (t table) home(hk uint32) int {
+	return int(hk%t.modPrime) % t.slots
+}
+
+// distance finds idx in the probe sequence of p by walking it.
+func distance(p Prober, hk uint32, idx int) int {
+	n := 0
+	for i := range p.Probe(hk) {
+		if i == idx {
+			return n
+		}
+		n++
+	}
+	return n
+}
+
+// linearProber tries home, home+1, home+2, ...
+type linearProber struct{ table }
+
+func (p linearProber) Probe(hk uint32) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		start := p.home(hk)
+		for i := 0; i < p.slots; i++ {
+			if !yield((start + i) % p.slots) {
+				return
+			}
+		}
+	}
+}
+
+func (p linearProber) Distance(hk uint32, idx int) int {
+	return (idx - p.home(hk) + p.slots) % p.slots
+}
+
+// quadraticProber tries home + i(i+1)/2. The triangular sequence covers the whole
+// table only when the slot count is a power of two, so after slots steps it falls
+// back to a linear sweep from home.
+type quadraticProber struct{ table }
+
+func (p quadraticProber) Probe(hk uint32) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		start := p.home(hk)
+		idx := start
+		for i := 0; i < p.slots; i++ {
+			if !yield(idx) {
+				return
+			}
+			idx = (idx + i + 1) % p.slots
+		}
+		for i := 0; i < p.slots; i++ {
+			if !yield((start + i) % p.slots) {
+				return
+			}
+		}
+	}
+}
+
+func (p quadraticProber) Distance(hk uint32, idx int) int {
+	return distance(p, hk, idx)
+}
+
+// doubleProber tries home + i*step, with step derived from a second hash of the
+// key and made coprime with the slot count so that the sequence covers the table.
+type doubleProber struct{ table }
+
+func (p doubleProber) step(hk uint32) int {
+	if p.slots == 1 {
+		return 1
+	}
+	h2 := hk>>16 | hk<<16
+	step := 1 + int(h2%uint32(p.slots-1))
+	for gcd(step, p.slots) != 1 {
+		step++
+	}
+	return step
+}
+
+func (p doubleProber) Probe(hk uint32) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		idx, step := p.home(hk), p.step(hk)
+		for i := 0; i < p.slots; i++ {
+			if !yield(idx) {
+				return
+			}
+			idx = (idx + step) % p.slots
+		}
+	}
+}
+
+func (p doubleProber) Distance(hk uint32, idx int) int {
+	return distance(p, hk, idx)
+}
+
+// robinHoodProber walks the same sequence as linear probing, but inserts keep
+// every cluster ordered by displacement: an entry that is further from its home
+// than the resident of a slot takes that slot and the resident moves on. Lookups
+// can then stop at the first resident closer to its home than the probe has come.
+// It is paired with DeleteBackwardShift, which preserves that order.
+type robinHoodProber struct{ linearProber }
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestProbeCoversTable(t *testing.T) {
+	for _, slots := range []int{31, 32, 100} {
+		tb := table{slots: slots, modPrime: uint32(closestPrime(slots))}
+		for _, p := range []Prober{quadraticProber{tb}, doubleProber{tb}, robinHoodProber{linearProber{tb}}} {
+			for hk := uint32(0); hk < 200; hk += 7 {
+				first := make(map[int]int)
+				n := 0
+				for idx := range p.Probe(hk) {
+					if _, ok := first[idx]; !ok {
+						first[idx] = n
+					}
+					n++
+				}
+				if len(first) != slots {
+					t.Fatalf("%T over %d slots: hash %d reaches %d slots", p, slots, hk, len(first))
+				}
+				for idx, d := range first {
+					if got := p.Distance(hk, idx); got != d {
+						t.Fatalf("%T over %d slots: Distance(%d, %d) = %d, want %d", p, slots, hk, idx, got, d)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestProbeStrategies(t *testing.T) {
+	for _, opts := range []CreateOptions{
+		{Probe: ProbeQuadratic},
+		{Probe: ProbeDouble},
+		{Probe: ProbeRobinHood, DeleteMode: DeleteBackwardShift},
+	} {
+		t.Run(opts.Probe.String(), func(t *testing.T) {
+			opts.Slots = 31
+			opts.Options = Options{MaxLoadFactor: -1, ReapInterval: -1}
+			db, err := Create(filepath.Join(t.TempDir(), "db.bin"), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			// Half of the keys collide on one home slot.
+			keys := keysHomedAt(db, 5, 10)
+			for i := 0; len(keys) < 20; i++ {
+				keys = append(keys, fmt.Sprintf("k%d", i))
+			}
+			insertAll(t, db, keys...)
+			for i := 0; i < len(keys); i += 3 {
+				if found, err := db.Delete(keys[i]); err != nil || !found {
+					t.Fatalf("Delete(%s): %v, %v", keys[i], found, err)
+				}
+			}
+			for i, key := range keys {
+				var v string
+				found, err := db.Select(key, &v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if found != (i%3 != 0) {
+					t.Fatalf("%s: found=%v after deleting every third key", key, found)
+				}
+			}
+			// The probe sequences reach every slot, so the table fills up completely.
+			n := db.used
+			for i := 0; ; i++ {
+				err := db.Insert(fmt.Sprintf("fill%d", i), i)
+				if errors.Is(err, ErrTableFull) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				n++
+			}
+			if n != db.slots {
+				t.Fatalf("table full with %d of %d slots used", n, db.slots)
+			}
+			for i, key := range keys {
+				var v string
+				if found, err := db.Select(key, &v); err != nil || found != (i%3 != 0) {
+					t.Fatalf("%s in a full table: found=%v, %v", key, found, err)
+				}
+			}
+		})
+	}
+}
+
+// A Robin Hood insert takes the slot of a resident closer to its home and moves
+// the resident on, where linear probing would have walked past it.
+func TestRobinHoodDisplacesRicherEntry(t *testing.T) {
+	db, err := Create(filepath.Join(t.TempDir(), "db.bin"), CreateOptions{
+		Slots:      31,
+		Probe:      ProbeRobinHood,
+		DeleteMode: DeleteBackwardShift,
+		Options:    Options{MaxLoadFactor: -1, ReapInterval: -1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const h = 10
+	x := keysHomedAt(db, h+1, 1)[0]
+	ks := keysHomedAt(db, h, 2)
+	insertAll(t, db, x, ks[0], ks[1])
+	wantLayout(t, db, map[int]string{h: ks[0], h + 1: ks[1], h + 2: x})
+
+	// A missing key homed at h+1 is ruled out at x, without walking further.
+	var v string
+	if found, err := db.Select(keysHomedAt(db, h+1, 2)[1], &v); err != nil || found {
+		t.Fatalf("missing key: found=%v, %v", found, err)
+	}
+	if _, err := db.Delete(ks[0]); err != nil {
+		t.Fatal(err)
+	}
+	wantLayout(t, db, map[int]string{h: ks[1], h + 1: x, h + 2: ""})
+}
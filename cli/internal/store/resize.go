@@ -0,0 +1,227 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// resizeSuffix names the shadow file a resize builds before renaming it over the DB file.
+const resizeSuffix = ".resize"
+
+// errRehashTooSmall means the target table could not hold every entry. Only
+// cuckoo hashing, whose inserts can fail well below full load, runs into it.
+var errRehashTooSmall = errors.New("rehash target too small")
+
+// maybeResize rehashes the table when occupied+deleted slots would cross
+// MaxLoadFactor once extra more entries are inserted. Writers call it before
+// they commit, so a failed resize fails the write instead of being reported
+// for a write that already happened. Caller holds db.wmu.
+func (db *DB) maybeResize(extra int) error {
+	if db.opts.MaxLoadFactor <= 0 {
+		return nil
+	}
+	db.mu.RLock()
+	load := float64(db.used+db.deleted+extra) / float64(db.slots)
+	limit := db.maxLoad()
+	db.mu.RUnlock()
+	if load <= limit {
+		return nil
+	}
+	return db.resize(0)
+}
+
+// maxLoad is the (occupied+deleted)/slots ratio the table may reach before growing.
+func (db *DB) maxLoad() float64 {
+	if _, ok := db.probe.(linHashProber); ok {
+		return db.opts.MaxLoadFactor / 2
+	}
+	return db.opts.MaxLoadFactor
+}
+
+// resize rehashes every live entry into a shadow file and atomically renames it
+// over the DB file. The table grows by GrowthFactor unless tombstones are most of
+// the load, in which case it is rebuilt at the same size. extra is the number of
+// entries about to be inserted; the new table is sized so they fit under MaxLoadFactor.
+// A linear hashing table grows by splitting instead (see growLinear) and is only
+// rebuilt here to drop its tombstones.
+//
+// Caller holds db.wmu, so no writer can run while the copy is made; the copy itself
+// only takes the read lock, so concurrent Select calls keep working until the
+// brief exclusive switch-over at the end.
+func (db *DB) resize(extra int) error {
+	db.mu.RLock()
+	slots := db.slots
+	need := int(float64(db.used+extra)/db.opts.MaxLoadFactor) + 1
+	if _, ok := db.probe.(linHashProber); ok {
+		// A split only drops the tombstones in the runs it re-places, so under
+		// delete/insert churn the others would keep the table growing.
+		if db.deleted < db.used || float64(db.used+extra)/float64(db.slots) > db.maxLoad() {
+			db.mu.RUnlock()
+			return db.growLinear(extra)
+		}
+	} else if float64(db.used)/float64(db.slots) >= db.opts.MinGrowLoadFactor || db.deleted == 0 || need > slots {
+		slots = closestPrime(max(int(float64(db.slots)*db.opts.GrowthFactor), need))
+		if slots <= db.slots {
+			slots = db.slots + 1
+		}
+	}
+	hdr := db.hdr
+	hdr.Slots = uint32(slots)
+	hdr.ModPrime = uint32(closestPrime(slots))
+	hdr.Resizes++
+	hdr.OverflowPages, hdr.OverflowFreeHead, hdr.OverflowFree = 0, 0, 0
+	tmp := db.path + resizeSuffix
+	hdr, used, err := db.rehashGrowing(tmp, hdr)
+	db.mu.RUnlock()
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("resize: %w", err)
+	}
+	if err := db.switchTo(tmp, hdr, used); err != nil {
+		return fmt.Errorf("resize: %w", err)
+	}
+	return nil
+}
+
+// switchTo replaces the DB file with the rebuilt file at tmp, described by hdr
+// and holding used live entries. Caller holds db.wmu but not db.mu.
+func (db *DB) switchTo(tmp string, hdr Header, used int) error {
+	f, err := os.OpenFile(tmp, os.O_RDWR, 0)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.preserveRest(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	// The log holds offsets into the old layout, so it must be empty before the
+	// new file takes its place.
+	if err := db.checkpoint(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	// Nothing else can have opened the new file yet, so the writer lock on it
+	// is free.
+	if err := lockFile(f, db.path, false, 0); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	_ = db.unmap()
+	_ = db.f.Close()
+	db.f = f
+	if db.pool != nil {
+		// Everything cached was checkpointed above and belongs to the old file.
+		if err := db.pool.drop(f); err != nil {
+			return err
+		}
+	}
+	db.hdr = hdr
+	db.slots = int(hdr.Slots)
+	db.modPrime = hdr.ModPrime
+	db.probe = newProber(&hdr)
+	db.used = used
+	db.deleted = 0
+	if db.opts.Mmap && mmapSupported {
+		if err := db.remap(); err != nil {
+			return err
+		}
+	}
+	if db.keys != nil {
+		// Every record may have moved.
+		if err := db.rebuildKeyIndex(); err != nil {
+			return err
+		}
+	}
+	// The WAL logs the next writes against the new file, so a crash must not
+	// forget the rename.
+	return syncDir(db.path)
+}
+
+// rehashGrowing is rehashInto that retries with a larger table, up to a few
+// times, while the entries do not fit in the one described by hdr. Caller holds
+// at least db.mu.RLock.
+func (db *DB) rehashGrowing(path string, hdr Header) (Header, int, error) {
+	for try := 0; ; try++ {
+		out, used, err := db.rehashInto(path, hdr)
+		if !errors.Is(err, errRehashTooSmall) || try == 3 {
+			return out, used, err
+		}
+		// A cuckoo layout hit a cycle at this size; grow further.
+		hdr.Slots = uint32(closestPrime(int(float64(hdr.Slots) * db.opts.GrowthFactor)))
+		hdr.ModPrime = uint32(closestPrime(int(hdr.Slots)))
+	}
+}
+
+// rehashInto writes a fresh file at path described by hdr containing every live
+// entry of db. Tombstones are dropped and overflow chains are rewritten compactly.
+// Returns the final header of the new file. Caller holds at least db.mu.RLock.
+func (db *DB) rehashInto(path string, hdr Header) (Header, int, error) {
+	if hdr.Probe == ProbeLinearHashing {
+		// The rebuilt table is contiguous: it becomes the new base.
+		hdr.BaseSlots, hdr.Segments = hdr.Slots, [maxSegments]uint32{}
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return hdr, 0, err
+	}
+	defer f.Close()
+	if err := f.Truncate(hdr.fileSize()); err != nil {
+		return hdr, 0, err
+	}
+
+	dst := &DB{f: f, hdr: hdr, slots: int(hdr.Slots), modPrime: hdr.ModPrime, probe: newProber(&hdr)}
+
+	// Lay the entries out in memory first, so that Robin Hood and cuckoo hashing
+	// can move them around freely; see place.
+	var hashes []uint32
+	var src []int
+	pos := make([]int, dst.slots)
+	buf := make([]byte, SlotSize)
+	for i := 0; i < db.slots; i++ {
+		s, err := db.peekSlot(i, buf)
+		if err != nil {
+			return hdr, 0, err
+		}
+		if s.state != StateOcc {
+			continue
+		}
+		hashes, src = append(hashes, s.hash), append(src, i)
+		if !place(dst.probe, pos, hashes, len(hashes)-1) {
+			return hdr, 0, errRehashTooSmall
+		}
+	}
+	for idx, p := range pos {
+		if p == 0 {
+			continue
+		}
+		s, err := db.readSlot(src[p-1])
+		if err != nil {
+			return hdr, 0, err
+		}
+		payload, err := db.payload(s)
+		if err != nil {
+			return hdr, 0, err
+		}
+		if err := dst.writeRecord(idx, s.hash, s.version, s.expires, payload); err != nil {
+			return hdr, 0, err
+		}
+	}
+	used := len(hashes)
+	if err := dst.writeHeader(); err != nil {
+		return hdr, 0, err
+	}
+	return dst.hdr, used, f.Sync()
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestResizeAtMaxLoadFactor(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	want := make(map[string]string)
+	insert := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("k%d", len(want))
+			want[key] = key
+			if err := db.Insert(key, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	stats := func() Stats {
+		t.Helper()
+		st, err := db.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+	// 23 of 31 slots stays under DefaultMaxLoadFactor; the 24th insert would not.
+	insert(23)
+	if st := stats(); st.Resizes != 0 || st.Total != 31 {
+		t.Fatalf("resized early: %d slots after %d resizes", st.Total, st.Resizes)
+	}
+	insert(1)
+	st := stats()
+	if st.Resizes != 1 || st.Total <= 31 || st.Occupied != 24 {
+		t.Fatalf("after crossing the load factor: %d slots, %d resizes, %d occupied", st.Total, st.Resizes, st.Occupied)
+	}
+	insert(200)
+	st = stats()
+	if load := float64(st.Occupied+st.Deleted) / float64(st.Total); load > DefaultMaxLoadFactor || st.Resizes < 2 {
+		t.Fatalf("load %.2f after %d resizes", load, st.Resizes)
+	}
+	wantValues(t, db, want)
+}
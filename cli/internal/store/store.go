@@ -1,28 +1,46 @@
 package store
 
 import (
+	"crypto/rand"
     "encoding/binary"
     "encoding/json"
     "errors"
     "fmt"
-	"hash/fnv"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
-	"reflect"
 	"sync"
+	"time"
 )
 
 const (
 	SlotSize     = 512
-	HeaderSize   = 1 + 4 + 2 // state(1) + key(uint32) + len(uint16)
+	HeaderSize   = 1 + 1 + 4 + 2 + 4 + 8 + 4 // state(1) + flags(1) + key(uint32) + len(uint16) + version(uint32) + expires(int64) + crc(uint32)
 	PayloadCap   = SlotSize - HeaderSize
 	StateEmpty   = 0
 	StateOcc     = 1
 	StateDeleted = 2
 )
 
-// Slot header is encoded as: state | key(uint32, LE) | payloadLen(uint16, LE)
+// Slot header is encoded as: state | flags | key(uint32, LE) | payloadLen(uint16, LE) | version(uint32, LE) |
+// expires(int64, LE, unix nanos, 0 = never) | crc32c(uint32, LE)
+// The checksum covers the header bytes before it and the payload; an all-zero slot is a valid empty slot.
+
+// slot is one decoded slot. data holds the raw bytes stored in the slot; for
+// flagOverflow slots it starts with a pointer into the overflow region (see overflow.go).
+type slot struct {
+	index int
+	state byte
+	flags byte
+	hash  uint32
+	// version is one past Header.LastVersion when a record is inserted and grows
+	// by one on every update.
+	version uint32
+	// expires is when the record stops being visible, in unix nanoseconds; 0 means never.
+	expires int64
+	data    []byte
+}
 
 var (
 	ErrKeyNotFound   = errors.New("key not found")
@@ -32,58 +50,537 @@ var (
 
 type DB struct {
 	f        *os.File
+	path     string
+	hdr      Header
+	opts     Options
 	slots    int
 	modPrime uint32
-	mu       sync.RWMutex
+	probe    Prober
+	hasher   Hasher
+	// used and deleted count StateOcc and StateDeleted slots; they drive resizing.
+	used    int
+	deleted int
+	mu      sync.RWMutex
+	// wmu serializes writers so a resize can copy the table under a read lock
+	// without a write slipping in between the copy and the switch-over.
+	wmu sync.Mutex
+	// wal is the write-ahead log; batch is the write set of the mutation
+	// currently holding mu exclusively (see wal.go).
+	wal     *os.File
+	walSize int64
+	batch   *batch
+	// lsn counts the bytes appended to the WAL since Open; group tracks how much
+	// of it is on stable storage (see durability.go).
+	lsn   uint64
+	group groupCommit
+	// stop ends background goroutines such as the scrubber; bg waits for them.
+	stop     chan struct{}
+	stopOnce sync.Once
+	bg       sync.WaitGroup
+	// indexes are the secondary indexes by name; idxOps queues their updates for
+	// the batch in progress (see index.go).
+	indexes map[string]*index
+	idxOps  []indexOp
+	// keys is the ordered key index when Options.KeyIndex is set; keyOps queues
+	// its updates like idxOps (see keyindex.go), and keysStale is the error that
+	// left it behind the table, if any.
+	keys      *btree
+	keyOps    []keyOp
+	keysStale error
+	// hasTTL is set once any record with an expiry time may be stored; until then
+	// writes skip looking for expired records (see ttl.go).
+	hasTTL bool
+	// mm maps the DB file when Options.Mmap is set (see mmap.go).
+	mm []byte
+	// pool caches the DB file when Options.CachePages is set (see bufpool.go).
+	pool *bufferPool
+	// lock holds the cross-process lock on the DB (see lock.go); readOnly is set
+	// for OpenOptions.ReadOnly, which shares it and rejects writes.
+	lock     *os.File
+	readOnly bool
+	// backup is the Backup in progress, if any; bmu lets only one run at a time
+	// (see backup.go).
+	backup *backup
+	bmu    sync.Mutex
+}
+
+// Options are runtime settings that are not recorded in the file.
+type Options struct {
+	// MaxLoadFactor is the (occupied+deleted)/slots ratio above which the table is rehashed.
+	// Zero means DefaultMaxLoadFactor; a negative value disables automatic resizing.
+	MaxLoadFactor float64
+	// MinGrowLoadFactor is the occupied/slots ratio below which a rehash keeps the
+	// current size and only drops tombstones. Zero means DefaultMinGrowLoadFactor.
+	MinGrowLoadFactor float64
+	// GrowthFactor multiplies the slot count when the table grows. Zero means DefaultGrowthFactor.
+	GrowthFactor float64
+	// CheckpointBytes is the WAL size that triggers a checkpoint. Zero means DefaultCheckpointBytes.
+	CheckpointBytes int64
+	// ScrubInterval, when positive, runs Verify in the background at that interval
+	// and passes each report to OnScrub.
+	ScrubInterval time.Duration
+	OnScrub       func(VerifyReport, error)
+	// ReapInterval is how often expired records are removed in the background.
+	// Zero means DefaultReapInterval; a negative value disables the reaper.
+	ReapInterval time.Duration
+	// Mmap reads the DB file through a memory mapping instead of pread calls.
+	Mmap bool
+	// CachePages, when positive, keeps that many 4 KiB frames of the DB file in a
+	// buffer pool and writes committed pages back lazily. Exclusive with Mmap.
+	CachePages int
+	// Durability selects when the WAL is fsynced. Zero means DurabilityNone.
+	Durability Durability
+	// GroupCommitWindow is how long a DurabilityGroup commit waits at most for
+	// the writers queued behind it to share its fsync. Zero means
+	// DefaultGroupCommitWindow; with a negative value writers only share an
+	// fsync that is already in progress.
+	GroupCommitWindow time.Duration
+	// LockTimeout is how long Open and Create wait for another process to
+	// release the DB before failing with ErrLocked. Zero fails at once.
+	LockTimeout time.Duration
+	// KeyIndex maintains a B+tree of the keys next to the DB file so that Range
+	// and Prefix can list them in order. Opening without it removes the tree.
+	KeyIndex bool
+}
+
+const (
+	DefaultMaxLoadFactor     = 0.75
+	DefaultMinGrowLoadFactor = 0.5
+	DefaultGrowthFactor      = 2.0
+	DefaultReapInterval      = time.Minute
+)
+
+func (o Options) withDefaults() Options {
+	if o.MaxLoadFactor == 0 {
+		o.MaxLoadFactor = DefaultMaxLoadFactor
+	}
+	if o.MinGrowLoadFactor == 0 {
+		o.MinGrowLoadFactor = DefaultMinGrowLoadFactor
+	}
+	if o.GrowthFactor <= 1 {
+		o.GrowthFactor = DefaultGrowthFactor
+	}
+	if o.CheckpointBytes <= 0 {
+		o.CheckpointBytes = DefaultCheckpointBytes
+	}
+	if o.ReapInterval == 0 {
+		o.ReapInterval = DefaultReapInterval
+	}
+	if o.GroupCommitWindow == 0 {
+		o.GroupCommitWindow = DefaultGroupCommitWindow
+	}
+	return o
+}
+
+// CreateOptions are the table parameters fixed at creation time and recorded in the file header.
+type CreateOptions struct {
+	// Slots is the number of fixed-size slots in the table.
+	Slots int
+	// Probe selects the collision resolution strategy. Zero means ProbeLinear.
+	Probe ProbeStrategy
+	// Hash selects the key hash function. Zero means HashFNV1a.
+	Hash HashAlg
+	// HashSeed keys a seeded hash function; zero picks a random seed.
+	HashSeed [2]uint64
+	// DeleteMode selects tombstone (default) or backward-shift deletion.
+	DeleteMode DeleteMode
+	// Compression selects how record payloads are compressed. Zero means CompressNone.
+	Compression Compression
+	Options
+}
+
+// OpenOptions control how an existing DB file is opened.
+type OpenOptions struct {
+	// Slots, when non-zero, must match the slot count recorded in the header.
+	Slots int
+	Options
+	// CreateIfMissing creates the file using Create when it does not exist yet.
+	CreateIfMissing bool
+	// ReadOnly opens the DB for reading only, sharing it with other readers but
+	// not with a writer. Writes fail with ErrReadOnly, nothing next to the DB file
+	// is touched, and a DB a writer did not close cleanly fails with ErrNeedsRecovery.
+	ReadOnly bool
+	// Create holds the parameters used when CreateIfMissing creates a new file.
+	Create CreateOptions
 }
 
-// Open creates or opens a fixed-slot DB file with given slot count.
-// If the file does not exist, it initializes it to size slots*SlotSize with empty slots.
-func Open(path string, slots int) (*DB, error) {
-	if slots <= 0 {
+// Create makes a new DB file with a header page followed by opts.Slots empty slots.
+// Fails with ErrExists if the file is already there.
+// Like Open, it locks the DB against other processes until Close.
+func Create(path string, opts CreateOptions) (db *DB, err error) {
+	if opts.Slots <= 0 {
 		return nil, fmt.Errorf("slots must be > 0")
 	}
+	if opts.Probe == 0 {
+		opts.Probe = ProbeLinear
+	}
+	if err := checkStrategies(opts.Probe, opts.DeleteMode); err != nil {
+		return nil, err
+	}
+	if opts.Probe == ProbeCuckoo && opts.Slots < 3 {
+		return nil, fmt.Errorf("cuckoo hashing needs at least 3 slots")
+	}
+	var base uint32
+	if opts.Probe == ProbeLinearHashing {
+		base = uint32(opts.Slots)
+	}
+	if opts.Hash == 0 {
+		opts.Hash = HashFNV1a
+	}
+	if opts.Hash > HashMurmur3 {
+		return nil, fmt.Errorf("unknown hash algorithm %d", opts.Hash)
+	}
+	if opts.Compression > CompressDeflate {
+		return nil, fmt.Errorf("unknown compression %d", opts.Compression)
+	}
+	if opts.Hash == HashFNV1a {
+		opts.HashSeed = [2]uint64{}
+	} else if opts.HashSeed == [2]uint64{} {
+		var b [16]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, err
+		}
+		opts.HashSeed = [2]uint64{binary.LittleEndian.Uint64(b[:8]), binary.LittleEndian.Uint64(b[8:])}
+	}
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil && !errors.Is(err, os.ErrExist) {
 		return nil, err
 	}
-
-	// Open read-write, create if not exists
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	lock, err := lockDB(path, false, opts.LockTimeout)
 	if err != nil {
 		return nil, err
 	}
-
-	// Ensure file size matches number of slots
-	wantSize := int64(slots * SlotSize)
-	stat, err := f.Stat()
+	defer func() {
+		if err != nil {
+			_ = lock.Close()
+			return
+		}
+		db.lock = lock
+	}()
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("%w: %s", ErrExists, path)
+		}
+		return nil, err
+	}
+	if err := lockFile(f, path, false, 0); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+	// A log or indexes left behind by a previous file at this path must not be
+	// applied to the new one.
+	if err := os.Remove(path + walSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+	if err := removeIndexFiles(path); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+	hdr := Header{
+		Version:     FormatVersion,
+		SlotSize:    SlotSize,
+		Slots:       uint32(opts.Slots),
+		ModPrime:    uint32(closestPrime(opts.Slots)),
+		Hash:        opts.Hash,
+		HashSeed:    opts.HashSeed,
+		Probe:       opts.Probe,
+		Delete:      opts.DeleteMode,
+		Compression: opts.Compression,
+		BaseSlots:   base,
+		CreatedAt:   time.Now().UTC(),
+	}
+	// Slots are zero-filled by the OS; zero state means empty.
+	if err := f.Truncate(HeaderPageSize + int64(opts.Slots)*SlotSize); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+	if _, err := f.WriteAt(hdr.encode(), 0); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+	wal, err := openWAL(path, f)
 	if err != nil {
 		_ = f.Close()
+		_ = os.Remove(path)
 		return nil, err
 	}
-	if stat.Size() != wantSize {
-		if err := f.Truncate(wantSize); err != nil {
+	return newDB(f, wal, path, hdr, opts.Options, false)
+}
+
+// Open opens an existing DB file, replays its write-ahead log and validates its header.
+// It locks the DB until Close, failing with ErrLocked if another process holds it
+// longer than opts.LockTimeout: a writer excludes everyone else, a ReadOnly open
+// only writers.
+// The file is never resized: a header that disagrees with opts or with the
+// file size results in an error instead. A file from before the header page
+// existed is converted first (see legacy.go).
+func Open(path string, opts OpenOptions) (db *DB, err error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if opts.CreateIfMissing && !opts.ReadOnly {
+			copts := opts.Create
+			copts.Options = opts.Options
+			return Create(path, copts)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNotExist, path)
+	}
+	lock, err := lockDB(path, opts.ReadOnly, opts.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = lock.Close()
+			return
+		}
+		db.lock = lock
+	}()
+	flag := os.O_RDWR
+	if opts.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNotExist, path)
+		}
+		return nil, err
+	}
+	if !opts.ReadOnly {
+		// Excludes a reader that locked the DB file for want of <db>.lock.
+		if err := lockFile(f, path, false, opts.LockTimeout); err != nil {
 			_ = f.Close()
 			return nil, err
 		}
-		// Newly grown regions are zero-filled by the OS; zero state means empty.
 	}
+	if lf, err := readLegacy(f); err != nil || lf != nil {
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if opts.ReadOnly {
+			return nil, fmt.Errorf("%w: %s has no file header", ErrNeedsRecovery, path)
+		}
+		if f, err = convertLegacy(path, lf); err != nil {
+			return nil, fmt.Errorf("convert %s: %w", path, err)
+		}
+	}
+	var wal *os.File
+	if opts.ReadOnly {
+		err = checkWAL(path)
+	} else {
+		wal, err = openWAL(path, f)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	hdr, err := readHeader(f)
+	if err == nil && opts.Slots != 0 && int(hdr.Slots) != opts.Slots {
+		err = &MismatchError{Field: "slots", Want: opts.Slots, Got: hdr.Slots}
+	}
+	if err != nil {
+		_ = wal.Close()
+		_ = f.Close()
+		return nil, err
+	}
+	return newDB(f, wal, path, *hdr, opts.Options, opts.ReadOnly)
+}
+
+// ReadHeader returns the decoded header of the DB file at path without opening it for writing.
+func ReadHeader(path string) (*Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readHeader(f)
+}
 
+func readHeader(f *os.File) (*Header, error) {
+	buf := make([]byte, HeaderPageSize)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: file shorter than header page", ErrBadHeader)
+		}
+		return nil, err
+	}
+	hdr, err := decodeHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if want := hdr.fileSize(); stat.Size() != want {
+		return nil, &MismatchError{Field: "file size", Want: want, Got: stat.Size()}
+	}
+	return hdr, nil
+}
+
+// newDB sets up a DB for f, which the caller has locked. wal is nil when readOnly.
+func newDB(f, wal *os.File, path string, hdr Header, opts Options, readOnly bool) (*DB, error) {
+	// A leftover shadow file means a resize was interrupted before the switch-over;
+	// the original file is still authoritative.
+	if !readOnly {
+		_ = os.Remove(path + resizeSuffix)
+	}
 	db := &DB{
 		f:        f,
-		slots:    slots,
-		modPrime: uint32(closestPrime(slots)),
+		wal:      wal,
+		path:     path,
+		hdr:      hdr,
+		opts:     opts.withDefaults(),
+		slots:    int(hdr.Slots),
+		modPrime: hdr.ModPrime,
+		probe:    newProber(&hdr),
+		hasher:   newHasher(hdr.Hash, hdr.HashSeed),
+		readOnly: readOnly,
+	}
+	if db.opts.CachePages > 0 {
+		if db.opts.Mmap {
+			_ = wal.Close()
+			_ = f.Close()
+			return nil, errPoolWithMmap
+		}
+		pool, err := newBufferPool(f, db.opts.CachePages)
+		if err != nil {
+			_ = wal.Close()
+			_ = f.Close()
+			return nil, err
+		}
+		db.pool = pool
+	}
+	if db.opts.Mmap && mmapSupported {
+		if err := db.remap(); err != nil {
+			_ = wal.Close()
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	if err := db.countStates(); err != nil {
+		_ = db.unmap()
+		_ = wal.Close()
+		_ = f.Close()
+		return nil, err
+	}
+	if hdr.Version <= jsonEnvelopeVersion && !readOnly {
+		if err := db.migrateEnvelopes(); err != nil {
+			_ = db.unmap()
+			_ = wal.Close()
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	if err := db.openKeyIndex(); err != nil {
+		_ = db.unmap()
+		_ = wal.Close()
+		_ = f.Close()
+		return nil, err
+	}
+	if err := db.openIndexes(); err != nil {
+		if db.keys != nil {
+			_ = db.keys.close(false)
+		}
+		_ = db.unmap()
+		_ = wal.Close()
+		_ = f.Close()
+		return nil, err
+	}
+	db.stop = make(chan struct{})
+	if db.opts.ScrubInterval > 0 {
+		db.bg.Add(1)
+		go db.scrub(db.opts.ScrubInterval, db.opts.OnScrub)
+	}
+	if db.opts.ReapInterval > 0 && !readOnly {
+		db.bg.Add(1)
+		go db.reap(db.opts.ReapInterval)
 	}
 	return db, nil
 }
 
+// countStates initializes the used/deleted counters from the slot states on disk.
+func (db *DB) countStates() error {
+	db.used, db.deleted = 0, 0
+	buf := make([]byte, SlotSize)
+	for i := 0; i < db.slots; i++ {
+		// A corrupt slot must not prevent opening the file; count it by its raw state
+		// and leave the reporting to Verify.
+		s, err := db.peekSlot(i, buf)
+		if err != nil && !errors.As(err, new(*ErrCorruptSlot)) {
+			return err
+		}
+		switch s.state {
+		case StateOcc:
+			db.used++
+			if s.expires != 0 {
+				db.hasTTL = true
+			}
+			db.hdr.LastVersion = max(db.hdr.LastVersion, s.version)
+		case StateDeleted:
+			db.deleted++
+		}
+	}
+	return nil
+}
+
+// Header returns the current file header.
+func (db *DB) Header() Header {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.hdr
+}
+
+// Close stops background work, checkpoints the WAL, closes the DB file and
+// its secondary indexes and releases the lock on the DB.
 func (db *DB) Close() error {
+	db.stopOnce.Do(func() { close(db.stop) })
+	db.bg.Wait()
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	if db.f == nil {
 		return nil
 	}
-	err := db.f.Close()
-	db.f = nil
+	err := db.checkpoint()
+	if db.keys != nil {
+		// A stale tree is rebuilt on the next Open whatever state it is left in.
+		if cerr := db.keys.close(err == nil && db.keysStale == nil); err == nil && db.keysStale == nil {
+			err = cerr
+		}
+		db.keys = nil
+	}
+	if len(db.indexes) > 0 {
+		if cerr := db.closeIndexes(); err == nil {
+			err = cerr
+		}
+		// Only a fully closed set of up-to-date indexes is trusted on the next Open.
+		if err == nil && !db.readOnly {
+			err = db.saveCatalog(!db.indexesStale())
+		}
+	}
+	if db.wal != nil {
+		if cerr := db.wal.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := db.unmap(); err == nil {
+		err = cerr
+	}
+	if cerr := db.f.Close(); err == nil {
+		err = cerr
+	}
+	if db.lock != nil {
+		if cerr := db.lock.Close(); err == nil {
+			err = cerr
+		}
+	}
+	db.f, db.wal, db.lock = nil, nil, nil
 	return err
 }
 
@@ -93,86 +590,160 @@ type Stats struct {
     Occupied int
     Deleted  int
     Total    int
+    // Resizes is the number of rehashes the table has gone through since creation.
+    Resizes int
+    // OverflowPages is the size of the overflow region; OverflowFree of those are on the free list.
+    // With linear hashing it includes the pages holding grown slot segments.
+    OverflowPages int
+    OverflowFree  int
+    // WALBytes is the size of the write-ahead log not yet checkpointed.
+    WALBytes int64
+    // Corrupt counts slots that failed checksum verification; they are not counted in Empty/Occupied/Deleted.
+    Corrupt int
+    // AvgProbe and MaxProbe measure how many slots a successful lookup reads
+    // under the table's probing strategy (1 = the record sits at its home slot).
+    AvgProbe float64
+    MaxProbe int
+    // Pool holds the buffer pool counters; it is zero without Options.CachePages.
+    Pool PoolStats
+    // PayloadBytes is the size of the live records as written and StoredBytes the
+    // size they take up in slots and overflow pages; Compressed of them are stored
+    // compressed. They only differ with Header.Compression.
+    PayloadBytes int64
+    StoredBytes  int64
+    Compressed   int
 }
 
 // Stats scans all slots and returns the distribution of states.
 func (db *DB) Stats() (Stats, error) {
     db.mu.RLock()
     defer db.mu.RUnlock()
-    var s Stats
+    s := Stats{
+        Resizes:       int(db.hdr.Resizes),
+        OverflowPages: int(db.hdr.OverflowPages),
+        OverflowFree:  int(db.hdr.OverflowFree),
+        WALBytes:      db.walSize,
+    }
+    buf := make([]byte, SlotSize)
     for i := 0; i < db.slots; i++ {
-        state, _, _, err := db.readSlot(i)
+        sl, err := db.peekSlot(i, buf)
+        s.Total++
         if err != nil {
+            if errors.As(err, new(*ErrCorruptSlot)) {
+                s.Corrupt++
+                continue
+            }
             return s, err
         }
-        s.Total++
-        switch state {
+        switch sl.state {
         case StateEmpty:
             s.Empty++
         case StateOcc:
             s.Occupied++
+            n := db.probe.Distance(sl.hash, i) + 1
+            s.AvgProbe += float64(n)
+            s.MaxProbe = max(s.MaxProbe, n)
+            raw, stored := payloadSizes(sl)
+            s.PayloadBytes += int64(raw)
+            s.StoredBytes += int64(stored)
+            if sl.flags&flagCompressed != 0 {
+                s.Compressed++
+            }
         case StateDeleted:
             s.Deleted++
         }
     }
+    if s.Occupied > 0 {
+        s.AvgProbe /= float64(s.Occupied)
+    }
+    if db.pool != nil {
+        s.Pool = db.pool.counters()
+    }
     return s, nil
 }
 
 // States returns a slice with the state byte of each slot in order.
+// Corrupt slots report their raw, unverified state byte.
 func (db *DB) States() ([]byte, error) {
     db.mu.RLock()
     defer db.mu.RUnlock()
     out := make([]byte, db.slots)
+    buf := make([]byte, SlotSize)
     for i := 0; i < db.slots; i++ {
-        state, _, _, err := db.readSlot(i)
-        if err != nil {
+        s, err := db.peekSlot(i, buf)
+        if err != nil && !errors.As(err, new(*ErrCorruptSlot)) {
             return nil, err
         }
-        out[i] = state
+        out[i] = s.state
     }
     return out, nil
 }
 
-// Clear resets all slots to StateEmpty and zero payloads.
-func (db *DB) Clear() error {
-    db.mu.Lock()
-    defer db.mu.Unlock()
-    zero := make([]byte, SlotSize)
-    for i := 0; i < db.slots; i++ {
-        off := int64(i * SlotSize)
-        if _, err := db.f.WriteAt(zero, off); err != nil {
-            return err
+// Clear resets all slots to StateEmpty and zero payloads and drops the overflow region.
+// It is logged as a single WAL batch, so a crash never leaves half a table behind.
+// Secondary indexes are emptied as well.
+func (db *DB) Clear() (err error) {
+    db.lockWriter()
+    defer db.unlockWriter(&err)
+    err = db.write(func() error {
+        db.used, db.deleted = 0, 0
+        db.hdr.OverflowPages, db.hdr.OverflowFreeHead, db.hdr.OverflowFree = 0, 0, 0
+        if db.hdr.Probe == ProbeLinearHashing {
+            // The grown slots live in the overflow region; start over from the base.
+            db.hdr.Slots, db.hdr.Segments = db.hdr.BaseSlots, [maxSegments]uint32{}
+            db.slots, db.probe = int(db.hdr.Slots), newProber(&db.hdr)
+        }
+        db.batch.resetFile(db.hdr.fileSize())
+        return db.writeHeader()
+    })
+    if err != nil {
+        return err
+    }
+    if db.keys != nil {
+        db.mu.Lock()
+        if err = db.keys.reset(); err == nil {
+            db.keysStale = nil
+        }
+        db.mu.Unlock()
+        if err != nil {
+            return fmt.Errorf("key index: %w", err)
         }
     }
-    return nil
+    return db.clearIndexes()
 }
 
 // SlotDetail describes the content of a slot at a given index.
 type SlotDetail struct {
-    Index int
-    State byte
-    Hash  uint32
-    Key   string
-    Type  string
-    Data  json.RawMessage
+    Index   int
+    State   byte
+    Hash    uint32
+    Version uint32
+    Key     string
+    Type    string
+    Data    json.RawMessage
 }
 
 // SlotDetail returns details for slot at index. For occupied slots, Key/Type/Data are filled from the envelope.
 func (db *DB) SlotDetail(index int) (SlotDetail, error) {
+    db.mu.RLock()
+    defer db.mu.RUnlock()
     var d SlotDetail
     d.Index = index
-    state, sk, payload, err := db.readSlot(index)
+    s, err := db.readSlot(index)
     if err != nil {
         return d, err
     }
-    d.State = state
-    d.Hash = sk
-    if state == StateOcc {
-        if env, err := decodeEnvelope(payload); err == nil {
-            d.Key = env.Key
-            d.Type = env.Type
-            d.Data = env.Data
+    d.State = s.state
+    d.Hash = s.hash
+    d.Version = s.version
+    if s.state == StateOcc {
+        env, err := db.slotEnvelope(s)
+        if err != nil {
+            return d, err
         }
+        d.Key = env.Key
+        d.Type = env.Type
+        d.Data = env.Data
     }
     return d, nil
 }
@@ -183,42 +754,90 @@ var ErrKeyExists = errors.New("key already exists")
 // Fails with ErrKeyExists if the key is already present.
 // Value is JSON-encoded with a small envelope that includes the original key and type name.
 func (db *DB) Insert(key string, v any) error {
-	payload, err := marshalEnvelope(key, v)
+	return db.insertExpiring(key, v, 0)
+}
+
+// insertExpiring is Insert for a record that expires at the given unix
+// nanosecond time, or never when expires is 0.
+func (db *DB) insertExpiring(key string, v any, expires int64) (err error) {
+	payload, err := encodePayload(key, v)
 	if err != nil {
 		return err
 	}
-	if len(payload) > PayloadCap {
-		return fmt.Errorf("%w: %d > %d", ErrPayloadTooBig, len(payload), PayloadCap)
+	hk := db.hashKey(key)
+
+	db.lockWriter()
+	defer db.unlockWriter(&err)
+
+	if err := db.maybeResize(1); err != nil {
+		return err
 	}
-	hk := hashKey(key)
-	start := int(hk % db.modPrime)
+	insert := func() error { return db.insert(key, hk, expires, payload) }
+	err = db.write(insert)
+	if errors.Is(err, ErrTableFull) && db.opts.MaxLoadFactor > 0 {
+		if err := db.resize(1); err != nil {
+			return err
+		}
+		err = db.write(insert)
+	}
+	return err
+}
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// insert stores a new record and queues its secondary index entries.
+// Caller runs it inside db.write.
+func (db *DB) insert(key string, hk uint32, expires int64, payload []byte) error {
+	if _, err := db.reclaimExpired(hk); err != nil {
+		return err
+	}
+	if err := db.insertSlot(key, hk, expires, payload); err != nil {
+		return err
+	}
+	if len(db.indexes) == 0 {
+		return nil
+	}
+	env, err := decodeEnvelope(payload)
+	if err != nil {
+		return err
+	}
+	return db.indexChange(key, nil, env)
+}
 
-	// Linear probing: record first deleted slot to reuse if key not found
+// insertSlot places payload in the first reusable slot of the probe chain.
+func (db *DB) insertSlot(key string, hk uint32, expires int64, payload []byte) error {
+	if expires != 0 {
+		db.hasTTL = true
+	}
+	switch p := db.probe.(type) {
+	case robinHoodProber:
+		return db.insertRobinHood(key, hk, expires, payload)
+	case cuckooProber:
+		return db.insertCuckoo(p, key, hk, expires, payload)
+	}
+	// Record first deleted slot to reuse if key not found
 	firstDel := -1
-	for probe := 0; probe < db.slots; probe++ {
-		idx := (start + probe) % db.slots
-		state, sk, existing, err := db.readSlot(idx)
+	for idx := range db.probe.Probe(hk) {
+		s, err := db.readSlot(idx)
 		if err != nil {
 			return err
 		}
-		switch state {
+		switch s.state {
 		case StateEmpty:
 			if firstDel >= 0 {
-				return db.writeSlot(firstDel, StateOcc, hk, payload)
+				return db.occupy(firstDel, StateDeleted, hk, expires, payload)
 			}
-			return db.writeSlot(idx, StateOcc, hk, payload)
+			return db.occupy(idx, StateEmpty, hk, expires, payload)
 		case StateDeleted:
 			if firstDel < 0 {
 				firstDel = idx
 			}
 		case StateOcc:
-			if sk == hk {
+			if s.hash == hk {
 				// Verify actual key match to avoid hash collision overwriting
-				env, derr := decodeEnvelope(existing)
-				if derr == nil && env.Key == key {
+				env, err := db.slotEnvelope(s)
+				if err != nil {
+					return err
+				}
+				if env.Key == key {
 					return ErrKeyExists
 				}
 			}
@@ -228,121 +847,290 @@ func (db *DB) Insert(key string, v any) error {
 		}
 	}
 	if firstDel >= 0 {
-		return db.writeSlot(firstDel, StateOcc, hk, payload)
+		return db.occupy(firstDel, StateDeleted, hk, expires, payload)
+	}
+	return ErrTableFull
+}
+
+// insertRobinHood walks the linear probe sequence carrying the entry to place.
+// Whenever the carried entry is further from its home than the resident of a
+// slot, the two swap and the walk continues with the evicted resident, which
+// keeps its version and overflow chain. Caller runs it inside db.write.
+func (db *DB) insertRobinHood(key string, hk uint32, expires int64, payload []byte) error {
+	_, _, env, err := db.find(key, hk)
+	if err != nil {
+		return err
+	}
+	if env != nil {
+		return ErrKeyExists
+	}
+	if db.used >= db.slots {
+		return ErrTableFull
+	}
+	// carry is the entry being placed; until the new record has been written it
+	// is described by hk and payload instead.
+	var carry slot
+	placed := false
+	put := func(idx int) error {
+		if placed {
+			return db.writeSlot(idx, carry)
+		}
+		placed = true
+		return db.writeRecord(idx, hk, db.hdr.LastVersion+1, expires, payload)
+	}
+	dist := 0
+	for idx := range db.probe.Probe(hk) {
+		s, err := db.readSlot(idx)
+		if err != nil {
+			return err
+		}
+		if s.state != StateOcc {
+			if err := put(idx); err != nil {
+				return err
+			}
+			db.used++
+			if s.state == StateDeleted {
+				db.deleted--
+			}
+			return nil
+		}
+		if d := db.probe.Distance(s.hash, idx); d < dist {
+			if err := put(idx); err != nil {
+				return err
+			}
+			carry, dist = s, d
+		}
+		dist++
 	}
 	return ErrTableFull
 }
 
+// occupy writes an occupied slot over a slot that was in state prev and updates the counters.
+func (db *DB) occupy(index int, prev byte, hk uint32, expires int64, payload []byte) error {
+	if err := db.writeRecord(index, hk, db.hdr.LastVersion+1, expires, payload); err != nil {
+		return err
+	}
+	db.used++
+	if prev == StateDeleted {
+		db.deleted--
+	}
+	return nil
+}
+
 // Select loads the record for key into out. Returns (found=false) if not present.
+// It keeps the signature it had before records were versioned, which existing
+// callers rely on; SelectVersion also returns the version.
 func (db *DB) Select(key string, out any) (bool, error) {
-	hk := hashKey(key)
-	start := int(hk % db.modPrime)
+	hk := db.hashKey(key)
 
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	for probe := 0; probe < db.slots; probe++ {
-		idx := (start + probe) % db.slots
-		state, sk, payload, err := db.readSlot(idx)
+	_, _, env, err := db.find(key, hk)
+	if err != nil || env == nil {
+		return false, err
+	}
+	if err := json.Unmarshal(env.Data, out); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// find walks the probe chain of key and returns the index, slot and envelope of
+// the record holding it. env is nil if the key is not present.
+// Caller holds db.mu.
+func (db *DB) find(key string, hk uint32) (int, slot, *envelope, error) {
+	_, robinHood := db.probe.(robinHoodProber)
+	_, cuckoo := db.probe.(cuckooProber)
+	n := 0
+	for idx := range db.probe.Probe(hk) {
+		s, err := db.readSlot(idx)
 		if err != nil {
-			return false, err
+			return -1, s, nil, err
 		}
-		switch state {
+		switch s.state {
 		case StateEmpty:
-			// Empty slot terminates search in linear probing
-			return false, nil
+			// Empty slot terminates search: an insert would have stopped here too.
+			// Cuckoo candidates are independent of each other, so check them all.
+			if !cuckoo {
+				return -1, slot{}, nil, nil
+			}
 		case StateDeleted:
 			// Keep probing
 		case StateOcc:
-			if sk == hk {
-				env, derr := decodeEnvelope(payload)
-				if derr == nil && env.Key == key {
-					if err := json.Unmarshal(env.Data, out); err != nil {
-						return false, err
+			if robinHood && db.probe.Distance(s.hash, idx) < n {
+				// The key would have displaced this entry on insert.
+				return -1, slot{}, nil, nil
+			}
+			if s.hash == hk {
+				env, err := db.slotEnvelope(s)
+				if err != nil {
+					return -1, s, nil, err
+				}
+				if env.Key == key {
+					if s.expired() {
+						return -1, slot{}, nil, nil
 					}
-					return true, nil
+					return idx, s, env, nil
 				}
 			}
 		default:
 			// continue
 		}
+		n++
 	}
-	return false, nil
+	return -1, slot{}, nil, nil
 }
 
 // Delete removes the record for key if present. Returns (found=false) if it didn't exist.
-func (db *DB) Delete(key string) (bool, error) {
-	hk := hashKey(key)
-	start := int(hk % db.modPrime)
+func (db *DB) Delete(key string) (found bool, err error) {
+	hk := db.hashKey(key)
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	db.lockWriter()
+	defer db.unlockWriter(&err)
 
-	for probe := 0; probe < db.slots; probe++ {
-		idx := (start + probe) % db.slots
-		state, sk, payload, err := db.readSlot(idx)
-		if err != nil {
-			return false, err
+	err = db.write(func() error {
+		var err error
+		found, err = db.delete(key, hk)
+		return err
+	})
+	return found, err
+}
+
+// delete frees the slot holding key. Caller runs it inside db.write.
+func (db *DB) delete(key string, hk uint32) (bool, error) {
+	if _, err := db.reclaimExpired(hk); err != nil {
+		return false, err
+	}
+	idx, s, env, err := db.find(key, hk)
+	if err != nil || env == nil {
+		return false, err
+	}
+	return true, db.removeAt(idx, s, env)
+}
+
+// removeAt frees slot idx holding the record s with envelope env according to
+// the header's DeleteMode. Caller runs it inside db.write.
+func (db *DB) removeAt(idx int, s slot, env *envelope) error {
+	// The record takes its version with it: persist that it was handed out.
+	if err := db.writeHeader(); err != nil {
+		return err
+	}
+	if len(db.indexes) > 0 {
+		if err := db.indexChange(env.Key, env, nil); err != nil {
+			return err
 		}
-		switch state {
-		case StateEmpty:
-			return false, nil
-		case StateOcc:
-			if sk == hk {
-				env, derr := decodeEnvelope(payload)
-				if derr == nil && env.Key == key {
-					if err := db.writeSlot(idx, StateDeleted, 0, nil); err != nil {
-						return false, err
-					}
-					return true, nil
-				}
-			}
-		case StateDeleted:
-			// continue
+	}
+	if db.keys != nil {
+		db.keyOps = append(db.keyOps, keyOp{key: env.Key, del: true})
+	}
+	if err := db.freeOverflow(s); err != nil {
+		return err
+	}
+	if _, ok := db.probe.(cuckooProber); ok {
+		if err := db.writeSlot(idx, slot{state: StateEmpty}); err != nil {
+			return err
 		}
+		db.used--
+		return nil
 	}
-	return false, nil
+	if db.hdr.Delete == DeleteBackwardShift {
+		if err := db.backwardShift(idx); err != nil {
+			return err
+		}
+		db.used--
+		return nil
+	}
+	if err := db.writeSlot(idx, slot{state: StateDeleted}); err != nil {
+		return err
+	}
+	db.used--
+	db.deleted++
+	return nil
 }
 
-// readSlot reads and decodes a slot at index into (state, key, payload)
-func (db *DB) readSlot(index int) (byte, uint32, []byte, error) {
-	off := int64(index * SlotSize)
-	buf := make([]byte, SlotSize)
-	if _, err := db.f.ReadAt(buf, off); err != nil {
-		return 0, 0, nil, err
+// readSlot reads, verifies and decodes the slot at index. On *ErrCorruptSlot the
+// returned slot still carries the raw state, flags and hash bytes.
+func (db *DB) readSlot(index int) (slot, error) {
+	s, err := db.peekSlot(index, nil)
+	if err != nil {
+		return s, err
+	}
+	data := make([]byte, len(s.data))
+	copy(data, s.data)
+	s.data = data
+	return s, nil
+}
+
+// peekSlot is readSlot without the copy of the payload, for scans that look at
+// slot headers and at most the start of the payload. The returned s.data aliases
+// the page, so it is only valid until db.mu is released or the page is written.
+// A page that has to be read from the file goes into buf when it is SlotSize
+// bytes long, which lets a scan reuse one buffer for every slot.
+func (db *DB) peekSlot(index int, buf []byte) (slot, error) {
+	page, err := db.pageInto(db.slotOffset(index), buf)
+	if err != nil {
+		return slot{}, err
+	}
+	s := slot{
+		index: index,
+		state: page[0],
+		flags: page[1],
+		hash:  binary.LittleEndian.Uint32(page[2:6]),
 	}
-	state := buf[0]
-	key := binary.LittleEndian.Uint32(buf[1:5])
-	plen := int(binary.LittleEndian.Uint16(buf[5:7]))
+	plen := int(binary.LittleEndian.Uint16(page[6:8]))
 	if plen < 0 || plen > PayloadCap {
-		return state, key, nil, fmt.Errorf("bad payload length")
+		return s, &ErrCorruptSlot{Index: index, Hash: s.hash, Reason: fmt.Sprintf("bad payload length %d", plen)}
 	}
-	payload := make([]byte, plen)
-	copy(payload, buf[HeaderSize:HeaderSize+plen])
-	return state, key, payload, nil
+	s.version = binary.LittleEndian.Uint32(page[8:12])
+	s.expires = int64(binary.LittleEndian.Uint64(page[12:20]))
+	crc := binary.LittleEndian.Uint32(page[20:24])
+	if !(crc == 0 && isZero(page)) && crc != slotChecksum(page[:20], page[HeaderSize:HeaderSize+plen]) {
+		return s, &ErrCorruptSlot{Index: index, Hash: s.hash, Reason: "checksum mismatch"}
+	}
+	s.data = page[HeaderSize : HeaderSize+plen : HeaderSize+plen]
+	return s, nil
 }
 
 // writeSlot encodes and writes one slot at index
-func (db *DB) writeSlot(index int, state byte, key uint32, payload []byte) error {
-	if len(payload) > PayloadCap {
-		return fmt.Errorf("%w: %d > %d", ErrPayloadTooBig, len(payload), PayloadCap)
+func (db *DB) writeSlot(index int, s slot) error {
+	if len(s.data) > PayloadCap {
+		return fmt.Errorf("%w: %d > %d", ErrPayloadTooBig, len(s.data), PayloadCap)
 	}
 	buf := make([]byte, SlotSize)
-	buf[0] = state
-	binary.LittleEndian.PutUint32(buf[1:5], key)
-	binary.LittleEndian.PutUint16(buf[5:7], uint16(len(payload)))
-	copy(buf[HeaderSize:], payload)
+	buf[0] = s.state
+	buf[1] = s.flags
+	binary.LittleEndian.PutUint32(buf[2:6], s.hash)
+	binary.LittleEndian.PutUint16(buf[6:8], uint16(len(s.data)))
+	binary.LittleEndian.PutUint32(buf[8:12], s.version)
+	binary.LittleEndian.PutUint64(buf[12:20], uint64(s.expires))
+	copy(buf[HeaderSize:], s.data)
+	binary.LittleEndian.PutUint32(buf[20:24], slotChecksum(buf[:20], s.data))
 
-	off := int64(index * SlotSize)
-	_, err := db.f.WriteAt(buf, off)
-	return err
+	if db.keys != nil && s.state == StateOcc {
+		if err := db.noteSlot(index, s); err != nil {
+			return err
+		}
+	}
+	return db.writeAt(buf, db.slotOffset(index))
+}
+
+// slotEnvelope decodes the envelope of an occupied slot, following its overflow chain if any.
+func (db *DB) slotEnvelope(s slot) (*envelope, error) {
+	payload, err := db.payload(s)
+	if err != nil {
+		return nil, err
+	}
+	env, err := decodeEnvelope(payload)
+	if err != nil {
+		return nil, &ErrCorruptSlot{Index: s.index, Hash: s.hash, Reason: "bad envelope: " + err.Error()}
+	}
+	return env, nil
 }
 
-// hashKey hashes a string key to uint32 using FNV-1a.
-func hashKey(s string) uint32 {
-	h := fnv.New32a()
-	_, _ = h.Write([]byte(s))
-	return h.Sum32()
+// hashKey hashes a string key with the file's hash function.
+func (db *DB) hashKey(s string) uint32 {
+	return db.hasher.Hash(s)
 }
 
 // closestPrime returns the prime number closest to n. If equidistant, returns the lower prime.
@@ -396,33 +1184,3 @@ func isPrime(x int) bool {
 	}
 	return true
 }
-
-// envelope stores the original key and JSON payload of the value.
-type envelope struct {
-	Key  string          `json:"key"`
-	Type string          `json:"type"`
-	Data json.RawMessage `json:"data"`
-}
-
-func marshalEnvelope(key string, v any) ([]byte, error) {
-	// Determine a friendly type name
-	t := reflect.TypeOf(v)
-	if t.Kind() == reflect.Pointer {
-		t = t.Elem()
-	}
-	typeName := t.String()
-	data, err := json.Marshal(v)
-	if err != nil {
-		return nil, err
-	}
-	env := envelope{Key: key, Type: typeName, Data: data}
-	return json.Marshal(env)
-}
-
-func decodeEnvelope(b []byte) (*envelope, error) {
-	var env envelope
-	if err := json.Unmarshal(b, &env); err != nil {
-		return nil, err
-	}
-	return &env, nil
-}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// createTestDB creates a DB with opts in a temporary directory and closes it
+// when the test ends. The reaper is off unless opts asks for it.
+func createTestDB(t *testing.T, opts CreateOptions) *DB {
+	t.Helper()
+	if opts.ReapInterval == 0 {
+		opts.ReapInterval = -1
+	}
+	db, err := Create(filepath.Join(t.TempDir(), "db.bin"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// wantValues fails unless every key in want holds its string value in db.
+func wantValues(t *testing.T, db *DB, want map[string]string) {
+	t.Helper()
+	for key, v := range want {
+		var got string
+		if found, err := db.Select(key, &got); err != nil || !found || got != v {
+			t.Fatalf("%s: found=%v, %d bytes, err=%v", key, found, len(got), err)
+		}
+	}
+}
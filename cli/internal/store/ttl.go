@@ -0,0 +1,168 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// A record inserted with a time-to-live carries its expiry time in the slot
+// header. Once that time has passed, lookups treat it as absent, but it keeps
+// its slot until it is reclaimed: every write first removes the expired records
+// on the probe chain it walks, and a background reaper (Options.ReapInterval)
+// sweeps the whole table. Removing an expired record is an ordinary delete, so
+// indexes and counters stay in step.
+
+// expired reports whether the record in s has outlived its time-to-live.
+func (s slot) expired() bool {
+	return s.expires != 0 && s.expires <= time.Now().UnixNano()
+}
+
+// InsertWithTTL is Insert for a record that disappears once ttl has elapsed.
+// Updates keep the expiry time of the record.
+func (db *DB) InsertWithTTL(key string, v any, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("ttl must be positive, got %s", ttl)
+	}
+	return db.insertExpiring(key, v, time.Now().Add(ttl).UnixNano())
+}
+
+// TTL returns how long the record for key has left to live; ok is false if it
+// never expires. Fails with ErrKeyNotFound if the key is absent or expired.
+func (db *DB) TTL(key string) (left time.Duration, ok bool, err error) {
+	hk := db.hashKey(key)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	_, s, env, err := db.find(key, hk)
+	if err != nil {
+		return 0, false, err
+	}
+	if env == nil {
+		return 0, false, ErrKeyNotFound
+	}
+	if s.expires == 0 {
+		return 0, false, nil
+	}
+	return time.Until(time.Unix(0, s.expires)), true, nil
+}
+
+// reclaimExpired removes the expired records on the probe chain of hk and
+// returns how many there were. Caller runs it inside db.write.
+func (db *DB) reclaimExpired(hk uint32) (int, error) {
+	if !db.hasTTL {
+		return 0, nil
+	}
+	_, cuckoo := db.probe.(cuckooProber)
+	n := 0
+	// Removing a record may shift the rest of the chain, so start over after each.
+	for {
+		found := false
+		for idx := range db.probe.Probe(hk) {
+			s, err := db.readSlot(idx)
+			if err != nil {
+				return n, err
+			}
+			if s.state == StateEmpty && !cuckoo {
+				break
+			}
+			if s.state != StateOcc || !s.expired() {
+				continue
+			}
+			env, err := db.slotEnvelope(s)
+			if err != nil {
+				return n, err
+			}
+			if err := db.removeAt(idx, s, env); err != nil {
+				return n, err
+			}
+			n++
+			found = true
+			break
+		}
+		if !found {
+			return n, nil
+		}
+	}
+}
+
+// ReapExpired removes every expired record from the table and returns how many
+// it removed. It works through the table a chunk at a time, each chunk as one
+// batch, so readers and writers are only held up briefly.
+func (db *DB) ReapExpired() (int, error) {
+	total := 0
+	for start := 0; ; start += verifyChunk {
+		hashes, done, err := db.expiredHashes(start, start+verifyChunk)
+		if err != nil {
+			return total, err
+		}
+		if len(hashes) > 0 {
+			n, err := db.reapChains(hashes)
+			total += n
+			if err != nil {
+				return total, err
+			}
+		}
+		if done {
+			return total, nil
+		}
+	}
+}
+
+// expiredHashes returns the key hashes of the expired records in slots [from, to)
+// and reports whether the end of the table was reached.
+func (db *DB) expiredHashes(from, to int) ([]uint32, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.f == nil || !db.hasTTL {
+		return nil, true, nil
+	}
+	var hashes []uint32
+	for i := from; i < to && i < db.slots; i++ {
+		s, err := db.readSlot(i)
+		if err != nil {
+			return nil, false, err
+		}
+		if s.state == StateOcc && s.expired() {
+			hashes = append(hashes, s.hash)
+		}
+	}
+	return hashes, to >= db.slots, nil
+}
+
+// reapChains reclaims the expired records on the probe chains of hashes.
+func (db *DB) reapChains(hashes []uint32) (int, error) {
+	db.wmu.Lock()
+	defer db.wmu.Unlock()
+	n := 0
+	err := db.write(func() error {
+		n = 0
+		for _, hk := range hashes {
+			m, err := db.reclaimExpired(hk)
+			n += m
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// reap runs ReapExpired at the given interval until the DB is closed.
+func (db *DB) reap(interval time.Duration) {
+	defer db.bg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-t.C:
+			_, _ = db.ReapExpired()
+		}
+	}
+}
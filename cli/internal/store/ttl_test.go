@@ -0,0 +1,105 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// insertExpired stores a record whose time-to-live has already run out.
+func insertExpired(t *testing.T, db *DB, key string) {
+	t.Helper()
+	if err := db.insertExpiring(key, key, time.Now().Add(-time.Second).UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTTLExpiry(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	if err := db.InsertWithTTL("live", "live", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("forever", "forever"); err != nil {
+		t.Fatal(err)
+	}
+	insertExpired(t, db, "gone")
+	if err := db.InsertWithTTL("bad", "bad", 0); err == nil {
+		t.Fatal("InsertWithTTL accepted a zero ttl")
+	}
+
+	var v string
+	if found, err := db.Select("gone", &v); err != nil || found {
+		t.Fatalf("Select of an expired record: found=%v, %v", found, err)
+	}
+	var keys []string
+	for key := range db.All() {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"forever", "live"}) {
+		t.Fatalf("Scan returned %q", keys)
+	}
+	if _, _, err := db.TTL("gone"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("TTL of an expired record: %v", err)
+	}
+	if left, ok, err := db.TTL("live"); err != nil || !ok || left <= 0 || left > time.Hour {
+		t.Fatalf("TTL(live) = %s, %v, %v", left, ok, err)
+	}
+	if _, ok, err := db.TTL("forever"); err != nil || ok {
+		t.Fatalf("TTL(forever) = %v, %v", ok, err)
+	}
+	if err := db.Update("gone", "again"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Update of an expired record: %v", err)
+	}
+	// Updates keep the expiry time.
+	if err := db.Update("live", "updated"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := db.TTL("live"); err != nil || !ok {
+		t.Fatalf("TTL after Update: %v, %v", ok, err)
+	}
+
+	// The expired record keeps its slot until a write on its chain reclaims it.
+	if st, err := db.Stats(); err != nil || st.Occupied != 3 {
+		t.Fatalf("Stats: %d occupied, %v", st.Occupied, err)
+	}
+	if err := db.Insert("gone", "again"); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := db.Select("gone", &v); err != nil || !found || v != "again" {
+		t.Fatalf("re-inserted record: found=%v value=%q err=%v", found, v, err)
+	}
+	if st, err := db.Stats(); err != nil || st.Occupied != 3 {
+		t.Fatalf("Stats after re-insert: %d occupied, %v", st.Occupied, err)
+	}
+
+	insertExpired(t, db, "a")
+	insertExpired(t, db, "b")
+	if n, err := db.ReapExpired(); err != nil || n != 2 {
+		t.Fatalf("ReapExpired = %d, %v", n, err)
+	}
+	if st, err := db.Stats(); err != nil || st.Occupied != 3 {
+		t.Fatalf("Stats after reaping: %d occupied, %v", st.Occupied, err)
+	}
+}
+
+func TestTTLReaper(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31, Options: Options{ReapInterval: 5 * time.Millisecond}})
+	if err := db.Insert("forever", "forever"); err != nil {
+		t.Fatal(err)
+	}
+	insertExpired(t, db, "gone")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		st, err := db.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Occupied == 1 && st.Deleted == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("reaper left %d occupied and %d deleted slots", st.Occupied, st.Deleted)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var ErrTxDone = errors.New("transaction already committed or rolled back")
+
+type txOpKind byte
+
+const (
+	txInsert txOpKind = iota + 1
+	txUpdate
+	txDelete
+)
+
+type txOp struct {
+	kind    txOpKind
+	key     string
+	payload []byte
+}
+
+// Tx buffers writes in memory and applies them all at once on Commit.
+// Reads through the Tx see its own buffered writes. A Tx holds no locks while
+// it is open; Commit re-validates every operation against the current table
+// and applies either all of them or none, as a single WAL batch.
+type Tx struct {
+	db   *DB
+	ops  []txOp
+	last map[string]int // index in ops of the latest write per key
+	done bool
+}
+
+// Begin starts a new transaction.
+func (db *DB) Begin() *Tx {
+	return &Tx{db: db, last: make(map[string]int)}
+}
+
+// Select loads the record for key into out, seeing writes buffered in the Tx.
+func (tx *Tx) Select(key string, out any) (bool, error) {
+	if tx.done {
+		return false, ErrTxDone
+	}
+	i, ok := tx.last[key]
+	if !ok {
+		return tx.db.Select(key, out)
+	}
+	op := tx.ops[i]
+	if op.kind == txDelete {
+		return false, nil
+	}
+	env, err := decodeEnvelope(op.payload)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(env.Data, out); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// exists reports whether key is visible to the Tx.
+func (tx *Tx) exists(key string) (bool, error) {
+	if i, ok := tx.last[key]; ok {
+		return tx.ops[i].kind != txDelete, nil
+	}
+	var raw json.RawMessage
+	return tx.db.Select(key, &raw)
+}
+
+func (tx *Tx) add(op txOp) {
+	tx.last[op.key] = len(tx.ops)
+	tx.ops = append(tx.ops, op)
+}
+
+// Insert buffers an insert. Fails with ErrKeyExists if the key is already visible to the Tx.
+func (tx *Tx) Insert(key string, v any) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	payload, err := encodePayload(key, v)
+	if err != nil {
+		return err
+	}
+	found, err := tx.exists(key)
+	if err != nil {
+		return err
+	}
+	if found {
+		return ErrKeyExists
+	}
+	tx.add(txOp{kind: txInsert, key: key, payload: payload})
+	return nil
+}
+
+// Update buffers an update. Fails with ErrKeyNotFound if the key is not visible to the Tx.
+func (tx *Tx) Update(key string, v any) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	payload, err := encodePayload(key, v)
+	if err != nil {
+		return err
+	}
+	found, err := tx.exists(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrKeyNotFound
+	}
+	tx.add(txOp{kind: txUpdate, key: key, payload: payload})
+	return nil
+}
+
+// Delete buffers a delete. Returns (found=false) if the key is not visible to the Tx.
+func (tx *Tx) Delete(key string) (bool, error) {
+	if tx.done {
+		return false, ErrTxDone
+	}
+	found, err := tx.exists(key)
+	if err != nil || !found {
+		return false, err
+	}
+	tx.add(txOp{kind: txDelete, key: key})
+	return true, nil
+}
+
+// Rollback discards the buffered writes.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.ops, tx.last = nil, nil
+	return nil
+}
+
+// Commit applies the buffered writes in order as one atomic batch. If any of
+// them no longer applies (say another writer inserted the same key meanwhile),
+// nothing is written and that error is returned.
+func (tx *Tx) Commit() (err error) {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	if len(tx.ops) == 0 {
+		return nil
+	}
+	db := tx.db
+
+	inserts := 0
+	for _, op := range tx.ops {
+		if op.kind == txInsert {
+			inserts++
+		}
+	}
+
+	db.lockWriter()
+	defer db.unlockWriter(&err)
+
+	if err := db.maybeResize(inserts); err != nil {
+		return err
+	}
+	apply := func() error {
+		for _, op := range tx.ops {
+			hk := db.hashKey(op.key)
+			var err error
+			switch op.kind {
+			case txInsert:
+				err = db.insert(op.key, hk, 0, op.payload)
+			case txUpdate:
+				err = db.update(op.key, hk, op.payload)
+			case txDelete:
+				// A concurrent delete of the same key is not a conflict.
+				_, err = db.delete(op.key, hk)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err = db.write(apply)
+	if errors.Is(err, ErrTableFull) && db.opts.MaxLoadFactor > 0 {
+		if err := db.resize(inserts); err != nil {
+			return err
+		}
+		err = db.write(apply)
+	}
+	return err
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// seedTx stores a=1 and b=2 in db.
+func seedTx(t *testing.T, db *DB) {
+	t.Helper()
+	for key, v := range map[string]int{"a": 1, "b": 2} {
+		if err := db.Insert(key, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// txValues reads keys from db, with -1 for the ones not found.
+func txValues(t *testing.T, db *DB, keys ...string) []int {
+	t.Helper()
+	out := make([]int, len(keys))
+	for i, key := range keys {
+		out[i] = -1
+		if _, err := db.Select(key, &out[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out
+}
+
+func TestTxCommit(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	seedTx(t, db)
+	tx := db.Begin()
+	if err := tx.Insert("c", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Update("a", 10); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := tx.Delete("b"); err != nil || !found {
+		t.Fatalf("Delete: %v, %v", found, err)
+	}
+	// Nothing is visible outside the Tx before Commit.
+	if got := txValues(t, db, "a", "b", "c"); !slices.Equal(got, []int{1, 2, -1}) {
+		t.Fatalf("before commit: %v", got)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := txValues(t, db, "a", "b", "c"); !slices.Equal(got, []int{10, -1, 3}) {
+		t.Fatalf("after commit: %v", got)
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Fatalf("second Commit: %v", err)
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	seedTx(t, db)
+	before, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := db.Begin()
+	if err := tx.Insert("c", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Update("a", 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if got := txValues(t, db, "a", "b", "c"); !slices.Equal(got, []int{1, 2, -1}) {
+		t.Fatalf("after rollback: %v", got)
+	}
+	after, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Occupied != before.Occupied || after.Deleted != before.Deleted || after.WALBytes != before.WALBytes {
+		t.Fatalf("rollback changed the table: %+v -> %+v", before, after)
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Fatalf("Commit after Rollback: %v", err)
+	}
+	if err := tx.Insert("d", 4); !errors.Is(err, ErrTxDone) {
+		t.Fatalf("Insert after Rollback: %v", err)
+	}
+}
+
+func TestTxReadsOwnWrites(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	seedTx(t, db)
+	tx := db.Begin()
+	defer tx.Rollback()
+	if err := tx.Insert("c", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Update("a", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Update("a", 11); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]int{"a": 11, "b": -1, "c": 3} {
+		v := -1
+		found, err := tx.Select(key, &v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != (want >= 0) || v != want {
+			t.Fatalf("tx.Select(%q) = %v, %d; want %d", key, found, v, want)
+		}
+	}
+	// A key the Tx deleted can be inserted again, one it inserted cannot.
+	if err := tx.Insert("b", 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Insert("c", 30); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("Insert of a key inserted by the Tx: %v", err)
+	}
+	if err := tx.Update("d", 4); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Update of a missing key: %v", err)
+	}
+}
+
+func TestTxCommitConflict(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	seedTx(t, db)
+
+	// Another writer inserts a key the Tx is about to insert.
+	tx := db.Begin()
+	if err := tx.Insert("c", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Update("a", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Insert("d", 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("d", 40); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("Commit: %v", err)
+	}
+	if got := txValues(t, db, "a", "c", "d"); !slices.Equal(got, []int{1, -1, 40}) {
+		t.Fatalf("after the failed commit: %v", got)
+	}
+
+	// Another writer deletes a key the Tx updates.
+	tx = db.Begin()
+	if err := tx.Insert("e", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Update("b", 20); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Commit: %v", err)
+	}
+	if got := txValues(t, db, "b", "e"); !slices.Equal(got, []int{-1, -1}) {
+		t.Fatalf("after the failed commit: %v", got)
+	}
+}
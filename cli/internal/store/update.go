@@ -0,0 +1,179 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Update replaces the value stored for key in place, keeping its slot.
+// Fails with ErrKeyNotFound if the key is not present. A value that no longer
+// fits in the slot spills into overflow pages; one larger than MaxPayloadSize
+// fails with ErrPayloadTooBig and leaves the old value untouched.
+func (db *DB) Update(key string, v any) (err error) {
+	payload, err := encodePayload(key, v)
+	if err != nil {
+		return err
+	}
+	hk := db.hashKey(key)
+
+	db.lockWriter()
+	defer db.unlockWriter(&err)
+
+	return db.write(func() error {
+		return db.update(key, hk, payload)
+	})
+}
+
+// Upsert stores the value for key, inserting it if absent and updating it in place otherwise.
+func (db *DB) Upsert(key string, v any) (err error) {
+	payload, err := encodePayload(key, v)
+	if err != nil {
+		return err
+	}
+	hk := db.hashKey(key)
+
+	db.lockWriter()
+	defer db.unlockWriter(&err)
+
+	if err := db.maybeResize(1); err != nil {
+		return err
+	}
+	upsert := func() error {
+		err := db.update(key, hk, payload)
+		if errors.Is(err, ErrKeyNotFound) {
+			return db.insert(key, hk, 0, payload)
+		}
+		return err
+	}
+	err = db.write(upsert)
+	if errors.Is(err, ErrTableFull) && db.opts.MaxLoadFactor > 0 {
+		if err := db.resize(1); err != nil {
+			return err
+		}
+		err = db.write(upsert)
+	}
+	return err
+}
+
+// Patch applies an RFC 7396 JSON merge patch to the value stored for key, in place.
+// Fails with ErrKeyNotFound if the key is not present.
+func (db *DB) Patch(key string, patch json.RawMessage) (err error) {
+	if !json.Valid(patch) {
+		return fmt.Errorf("invalid merge patch")
+	}
+	hk := db.hashKey(key)
+
+	db.lockWriter()
+	defer db.unlockWriter(&err)
+
+	return db.write(func() error {
+		_, _, env, err := db.find(key, hk)
+		if err != nil {
+			return err
+		}
+		if env == nil {
+			return ErrKeyNotFound
+		}
+		data, err := mergePatch(env.Data, patch)
+		if err != nil {
+			return err
+		}
+		payload := encodeEnvelope(&envelope{Key: key, Type: env.Type, Data: data})
+		if len(payload) > MaxPayloadSize {
+			return fmt.Errorf("%w: %d > %d", ErrPayloadTooBig, len(payload), MaxPayloadSize)
+		}
+		return db.update(key, hk, payload)
+	})
+}
+
+// update rewrites the record for key at its current slot and bumps its version.
+// The record keeps its expiry time. Caller runs it inside db.write.
+func (db *DB) update(key string, hk uint32, payload []byte) error {
+	return db.updateIfVersion(key, hk, 0, payload)
+}
+
+// updateIfVersion is update that, when expected is non-zero, first checks the
+// stored version against it. Caller runs it inside db.write.
+func (db *DB) updateIfVersion(key string, hk, expected uint32, payload []byte) error {
+	if _, err := db.reclaimExpired(hk); err != nil {
+		return err
+	}
+	idx, s, env, err := db.find(key, hk)
+	if err != nil {
+		return err
+	}
+	if env == nil {
+		return ErrKeyNotFound
+	}
+	if expected != 0 && s.version != expected {
+		return &ErrVersionConflict{Key: key, Expected: expected, Actual: s.version}
+	}
+	if len(db.indexes) > 0 {
+		next, err := decodeEnvelope(payload)
+		if err != nil {
+			return err
+		}
+		if err := db.indexChange(key, env, next); err != nil {
+			return err
+		}
+	}
+	if err := db.freeOverflow(s); err != nil {
+		return err
+	}
+	return db.writeRecord(idx, hk, s.version+1, s.expires, payload)
+}
+
+// encodePayload builds the envelope for v and checks it against MaxPayloadSize.
+func encodePayload(key string, v any) ([]byte, error) {
+	payload, err := marshalEnvelope(key, v)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("%w: %d > %d", ErrPayloadTooBig, len(payload), MaxPayloadSize)
+	}
+	return payload, nil
+}
+
+// mergePatch applies patch to target following RFC 7396.
+func mergePatch(target, patch json.RawMessage) (json.RawMessage, error) {
+	var t, p any
+	if len(target) > 0 {
+		if err := unmarshalNumber(target, &t); err != nil {
+			return nil, err
+		}
+	}
+	if err := unmarshalNumber(patch, &p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergeValue(t, p))
+}
+
+func mergeValue(target, patch any) any {
+	pm, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	tm, ok := target.(map[string]any)
+	if !ok {
+		tm = make(map[string]any)
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(tm, k)
+			continue
+		}
+		tm[k] = mergeValue(tm[k], v)
+	}
+	return tm
+}
+
+// unmarshalNumber decodes JSON keeping numbers as json.Number so that patching
+// does not round large integers through float64.
+func unmarshalNumber(b []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
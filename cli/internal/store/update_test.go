@@ -0,0 +1,87 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUpdateMissingKey(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	if err := db.Update("nope", 1); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := db.Patch("nope", json.RawMessage(`{}`)); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Patch: %v", err)
+	}
+	if st, err := db.Stats(); err != nil || st.Occupied != 0 {
+		t.Fatalf("Stats: %d occupied, %v", st.Occupied, err)
+	}
+	// Upsert inserts the missing key, then updates it.
+	for _, v := range []string{"first", "second"} {
+		if err := db.Upsert("k", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantValues(t, db, map[string]string{"k": "second"})
+	if st, err := db.Stats(); err != nil || st.Occupied != 1 {
+		t.Fatalf("Stats after Upsert: %d occupied, %v", st.Occupied, err)
+	}
+}
+
+func TestPatchMerge(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	doc := map[string]any{"name": "a", "tags": []string{"x"}, "address": map[string]any{"city": "Kyiv", "zip": "01001"}}
+	if err := db.Insert("k", doc); err != nil {
+		t.Fatal(err)
+	}
+	// null removes a member, objects merge recursively and arrays are replaced.
+	patch := `{"name":null,"tags":["y","z"],"address":{"zip":null,"street":"Main"},"age":3}`
+	if err := db.Patch("k", json.RawMessage(patch)); err != nil {
+		t.Fatal(err)
+	}
+	var got json.RawMessage
+	if found, err := db.Select("k", &got); err != nil || !found {
+		t.Fatalf("Select: found=%v, %v", found, err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(got, &m); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"address":{"city":"Kyiv","street":"Main"},"age":3,"tags":["y","z"]}`
+	if b, _ := json.Marshal(m); string(b) != want {
+		t.Fatalf("patched to %s, want %s", b, want)
+	}
+	if err := db.Patch("k", json.RawMessage(`{`)); err == nil {
+		t.Fatal("Patch accepted invalid JSON")
+	}
+}
+
+func TestUpdateIntoOverflow(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	if err := db.Insert("k", "small"); err != nil {
+		t.Fatal(err)
+	}
+	idx, _, _, err := db.find("k", db.hashKey("k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := strings.Repeat("x", 3*SlotSize)
+	if err := db.Update("k", big); err != nil {
+		t.Fatal(err)
+	}
+	// The record grows into an overflow chain but keeps its slot.
+	if got, _, _, err := db.find("k", db.hashKey("k")); err != nil || got != idx {
+		t.Fatalf("record moved from slot %d to %d: %v", idx, got, err)
+	}
+	if slotFlags(t, db, "k")&flagOverflow == 0 {
+		t.Fatal("grown value stored without overflow pages")
+	}
+	wantValues(t, db, map[string]string{"k": big})
+
+	if err := db.Update("k", strings.Repeat("x", MaxPayloadSize)); !errors.Is(err, ErrPayloadTooBig) {
+		t.Fatalf("Update over MaxPayloadSize: %v", err)
+	}
+	wantValues(t, db, map[string]string{"k": big})
+}
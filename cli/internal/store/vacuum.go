@@ -0,0 +1,90 @@
+package store
+
+import (
+	"fmt"
+	"os"
+)
+
+// VacuumReport compares the table before and after a Vacuum.
+type VacuumReport struct {
+	Records           int
+	TombstonesRemoved int
+	// AvgProbeBefore and AvgProbeAfter are the mean number of slots a successful
+	// lookup reads (1 when every record sits at its home slot); MaxProbe* the worst case.
+	AvgProbeBefore float64
+	AvgProbeAfter  float64
+	MaxProbeBefore int
+	MaxProbeAfter  int
+}
+
+// Vacuum rebuilds the table at its current size through a shadow file, dropping
+// every tombstone and re-inserting each live record as close to its home slot as
+// the probing strategy allows. Overflow chains are rewritten compactly too.
+// A cuckoo table that no longer fits at its size grows as in a resize.
+// Readers keep working during the copy, as with a resize; writers wait.
+func (db *DB) Vacuum() (VacuumReport, error) {
+	if db.readOnly {
+		return VacuumReport{}, ErrReadOnly
+	}
+	db.wmu.Lock()
+	defer db.wmu.Unlock()
+
+	var r VacuumReport
+	db.mu.RLock()
+	if db.f == nil {
+		db.mu.RUnlock()
+		return r, fmt.Errorf("vacuum: database closed")
+	}
+	var err error
+	r.Records, r.TombstonesRemoved, r.AvgProbeBefore, r.MaxProbeBefore, err = db.probeStats()
+	if err != nil {
+		db.mu.RUnlock()
+		return r, fmt.Errorf("vacuum: %w", err)
+	}
+	hdr := db.hdr
+	hdr.Vacuums++
+	hdr.OverflowPages, hdr.OverflowFreeHead, hdr.OverflowFree = 0, 0, 0
+	tmp := db.path + resizeSuffix
+	hdr, used, err := db.rehashGrowing(tmp, hdr)
+	db.mu.RUnlock()
+	if err != nil {
+		_ = os.Remove(tmp)
+		return r, fmt.Errorf("vacuum: %w", err)
+	}
+	if err := db.switchTo(tmp, hdr, used); err != nil {
+		return r, fmt.Errorf("vacuum: %w", err)
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	_, _, r.AvgProbeAfter, r.MaxProbeAfter, err = db.probeStats()
+	if err != nil {
+		return r, fmt.Errorf("vacuum: %w", err)
+	}
+	return r, nil
+}
+
+// probeStats counts live records and tombstones and measures how far records sit
+// from their home slot. Caller holds db.mu.
+func (db *DB) probeStats() (records, tombstones int, avg float64, longest int, err error) {
+	total := 0
+	for i := 0; i < db.slots; i++ {
+		s, err := db.readSlot(i)
+		if err != nil {
+			return 0, 0, 0, 0, err
+		}
+		switch s.state {
+		case StateDeleted:
+			tombstones++
+		case StateOcc:
+			records++
+			n := db.probe.Distance(s.hash, i) + 1
+			total += n
+			longest = max(longest, n)
+		}
+	}
+	if records > 0 {
+		avg = float64(total) / float64(records)
+	}
+	return records, tombstones, avg, longest, nil
+}
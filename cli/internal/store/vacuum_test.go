@@ -0,0 +1,105 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestVacuumCuckoo(t *testing.T) {
+	db, err := Create(filepath.Join(t.TempDir(), "db.bin"), CreateOptions{
+		Slots:   61,
+		Probe:   ProbeCuckoo,
+		Options: Options{MaxLoadFactor: -1, ReapInterval: -1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 40; i++ {
+		if err := db.Insert(fmt.Sprintf("k%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 40; i += 3 {
+		if _, err := db.Delete(fmt.Sprintf("k%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := db.Vacuum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Records != 26 {
+		t.Fatalf("vacuum saw %d records, want 26", r.Records)
+	}
+	for i := 0; i < 40; i++ {
+		var v int
+		found, err := db.Select(fmt.Sprintf("k%d", i), &v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i%3 != 0; found != want || (found && v != i) {
+			t.Fatalf("k%d: found=%v value=%d after vacuum", i, found, v)
+		}
+	}
+}
+
+// A table that no longer fits at its own size has to grow while it is vacuumed,
+// as it would in a resize.
+func TestVacuumCuckooGrows(t *testing.T) {
+	db, err := Create(filepath.Join(t.TempDir(), "db.bin"), CreateOptions{
+		Slots:   7,
+		Probe:   ProbeCuckoo,
+		Options: Options{MaxLoadFactor: -1, ReapInterval: -1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	p := db.probe.(cuckooProber)
+
+	// Four keys sharing both positions only fit in them and the one-slot stash
+	// three at a time. Inserts never build such a layout, so write it directly,
+	// the fourth record in a slot that is not one of its positions.
+	var keys []string
+	var hashes []uint32
+	for i := 0; len(keys) < 4; i++ {
+		key := fmt.Sprintf("k%d", i)
+		hk := db.hashKey(key)
+		if len(hashes) > 0 && (p.first(hk) != p.first(hashes[0]) || p.second(hk) != p.second(hashes[0])) {
+			continue
+		}
+		keys, hashes = append(keys, key), append(hashes, hk)
+	}
+	spare := (p.first(hashes[0]) + 1) % p.half
+	err = db.write(func() error {
+		for i, idx := range []int{p.first(hashes[0]), p.second(hashes[0]), 2 * p.half, spare} {
+			payload, err := encodePayload(keys[i], i)
+			if err != nil {
+				return err
+			}
+			if err := db.writeRecord(idx, hashes[i], uint32(i+1), 0, payload); err != nil {
+				return err
+			}
+		}
+		db.used = 4
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Vacuum(); err != nil {
+		t.Fatal(err)
+	}
+	if h := db.Header(); h.Slots <= 7 {
+		t.Fatalf("vacuum kept %d slots", h.Slots)
+	}
+	for i, key := range keys {
+		var v int
+		if found, err := db.Select(key, &v); err != nil || !found || v != i {
+			t.Fatalf("%s: found=%v value=%d err=%v", key, found, v, err)
+		}
+	}
+}
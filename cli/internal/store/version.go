@@ -0,0 +1,120 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrVersionConflict is returned by the compare-and-swap operations when the
+// stored version of a record is not the one the caller expected.
+// Actual is 0 when the record does not exist.
+type ErrVersionConflict struct {
+	Key      string
+	Expected uint32
+	Actual   uint32
+}
+
+func (e *ErrVersionConflict) Error() string {
+	return fmt.Sprintf("version conflict on %q: expected %d, found %d", e.Key, e.Expected, e.Actual)
+}
+
+// SelectVersion is Select that also returns the version of the record. It is
+// separate so that Select keeps its signature for existing callers. Every
+// update adds one to it, and an insert starts above every version the table has
+// handed out before, so versions keep growing when a key is deleted and
+// inserted again: a version names one state of one record, never a later one.
+func (db *DB) SelectVersion(key string, out any) (uint32, bool, error) {
+	hk := db.hashKey(key)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	_, s, env, err := db.find(key, hk)
+	if err != nil || env == nil {
+		return 0, false, err
+	}
+	if err := json.Unmarshal(env.Data, out); err != nil {
+		return 0, false, err
+	}
+	return s.version, true, nil
+}
+
+// CompareAndSwap replaces the value for key only if its current version is
+// expectedVersion. An expectedVersion of 0 means the key must not exist yet and
+// inserts it. Fails with *ErrVersionConflict otherwise. Since versions are never
+// reused, a version read before the key was deleted and inserted again does not
+// match the new record. An expectedVersion of 0 only says the key is absent now,
+// whatever happened to it since the caller looked.
+func (db *DB) CompareAndSwap(key string, expectedVersion uint32, v any) (err error) {
+	payload, err := encodePayload(key, v)
+	if err != nil {
+		return err
+	}
+	hk := db.hashKey(key)
+
+	db.lockWriter()
+	defer db.unlockWriter(&err)
+
+	if expectedVersion == 0 {
+		return db.insertIfAbsent(key, hk, payload)
+	}
+	return db.write(func() error {
+		err := db.updateIfVersion(key, hk, expectedVersion, payload)
+		if errors.Is(err, ErrKeyNotFound) {
+			return &ErrVersionConflict{Key: key, Expected: expectedVersion}
+		}
+		return err
+	})
+}
+
+// insertIfAbsent inserts payload for key, failing with *ErrVersionConflict
+// carrying the stored version if the key exists. The check and the insert run in
+// one batch, so no other write can come between them. Caller holds the writer lock.
+func (db *DB) insertIfAbsent(key string, hk uint32, payload []byte) error {
+	if err := db.maybeResize(1); err != nil {
+		return err
+	}
+	insert := func() error {
+		_, s, env, err := db.find(key, hk)
+		if err != nil {
+			return err
+		}
+		if env != nil {
+			return &ErrVersionConflict{Key: key, Expected: 0, Actual: s.version}
+		}
+		return db.insert(key, hk, 0, payload)
+	}
+	err := db.write(insert)
+	if errors.Is(err, ErrTableFull) && db.opts.MaxLoadFactor > 0 {
+		if err := db.resize(1); err != nil {
+			return err
+		}
+		err = db.write(insert)
+	}
+	return err
+}
+
+// DeleteIfVersion deletes key only if its current version is expectedVersion.
+// Fails with *ErrVersionConflict if the versions differ or the key is missing.
+func (db *DB) DeleteIfVersion(key string, expectedVersion uint32) (err error) {
+	hk := db.hashKey(key)
+
+	db.lockWriter()
+	defer db.unlockWriter(&err)
+
+	return db.write(func() error {
+		_, s, env, err := db.find(key, hk)
+		if err != nil {
+			return err
+		}
+		if env == nil {
+			return &ErrVersionConflict{Key: key, Expected: expectedVersion}
+		}
+		if s.version != expectedVersion {
+			return &ErrVersionConflict{Key: key, Expected: expectedVersion, Actual: s.version}
+		}
+		_, err = db.delete(key, hk)
+		return err
+	})
+}
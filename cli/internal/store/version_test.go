@@ -0,0 +1,101 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestVersionsSurviveDeleteAndReinsert(t *testing.T) {
+	for _, opts := range []CreateOptions{
+		{Slots: 31, Probe: ProbeLinear},
+		{Slots: 31, Probe: ProbeCuckoo},
+		{Slots: 31, Probe: ProbeLinear, DeleteMode: DeleteBackwardShift},
+	} {
+		t.Run(opts.Probe.String()+"/"+opts.DeleteMode.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db.bin")
+			opts.Options.ReapInterval = -1
+			db, err := Create(path, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Insert("k", 1); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Update("k", 2); err != nil {
+				t.Fatal(err)
+			}
+			var v int
+			old, _, err := db.SelectVersion("k", &v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Delete("k"); err != nil {
+				t.Fatal(err)
+			}
+			// The version handed out must outlive the record across a reopen.
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if db, err = Open(path, OpenOptions{Options: opts.Options}); err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if err := db.Insert("k", 3); err != nil {
+				t.Fatal(err)
+			}
+			now, _, err := db.SelectVersion("k", &v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if now <= old {
+				t.Fatalf("re-inserted record has version %d, not above %d", now, old)
+			}
+			var conflict *ErrVersionConflict
+			if err := db.CompareAndSwap("k", old, 4); !errors.As(err, &conflict) {
+				t.Fatalf("CompareAndSwap with the version from before the delete: %v", err)
+			}
+			if err := db.CompareAndSwap("k", now, 4); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCompareAndSwapInsertIsAtomic(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 61})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var wins int
+	var errs []error
+	for round := 0; round < 50; round++ {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := db.CompareAndSwap("k", 0, i)
+				var conflict *ErrVersionConflict
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case err == nil:
+					wins++
+				case !errors.As(err, &conflict) || conflict.Actual == 0:
+					// A conflict always names the record that was in the way.
+					errs = append(errs, err)
+				}
+			}()
+		}
+		wg.Wait()
+		if wins != round+1 {
+			t.Fatalf("round %d: %d inserts won in total", round, wins)
+		}
+		if _, err := db.Delete("k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(errs) > 0 {
+		t.Fatal(errs[0])
+	}
+}
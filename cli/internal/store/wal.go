@@ -0,0 +1,441 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Every mutation runs as a batch: page writes are buffered in memory, appended to
+// the write-ahead log (<db>.wal) as redo records followed by a commit record, and
+// only then applied to the DB file. On Open the log is replayed so that a crash in
+// the middle of applying a batch is repaired; batches without a commit record are
+// discarded. A checkpoint syncs the DB file and empties the log.
+//
+// A resize or Vacuum checkpoints and then renames a new DB file into place. The
+// commit record carries the header generation the batch was written against, and
+// a log whose generation differs from the file's, as when a crash lost the rename,
+// is refused with ErrWALMismatch rather than replayed onto the wrong layout.
+//
+// WAL record layout (little-endian):
+//
+//	len(uint32, body length) | crc32c(uint32, of kind+body) | kind(uint8) | body
+//
+// Bodies:
+//
+//	recPage:   offset(int64) | page bytes
+//	recReset:  size(int64)   -- zero everything past the header page, then size the file to size
+//	recGrow:   size(int64)   -- extend the file with zeroes to at least size
+//	recCommit: generation(uint32) -- Header.generation of the file; empty in older logs
+const (
+	walSuffix = ".wal"
+
+	recPage   = 1
+	recReset  = 2
+	recCommit = 3
+	recGrow   = 4
+
+	walRecHeader = 4 + 4 + 1
+
+	// DefaultCheckpointBytes is the WAL size that triggers an automatic checkpoint.
+	DefaultCheckpointBytes = 4 << 20
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrWALMismatch means the WAL was written against another generation of the DB
+// file than the one on disk.
+var ErrWALMismatch = errors.New("write-ahead log does not match the database file")
+
+// batch is the write set of the mutation currently holding db.mu exclusively.
+type batch struct {
+	// reset, when >= 0, zeroes the file past the header and sizes it to reset
+	// before any page is applied.
+	reset int64
+	// grow, when > 0, extends the file with zeroes to at least that size.
+	grow  int64
+	pages map[int64][]byte
+	order []int64
+}
+
+func newBatch() *batch {
+	return &batch{reset: -1, pages: make(map[int64][]byte)}
+}
+
+func (b *batch) put(off int64, page []byte) {
+	if _, ok := b.pages[off]; !ok {
+		b.order = append(b.order, off)
+	}
+	b.pages[off] = page
+}
+
+// resetFile drops the pages buffered so far and schedules a reset to size.
+func (b *batch) resetFile(size int64) {
+	b.reset = size
+	b.grow = 0
+	b.pages = make(map[int64][]byte)
+	b.order = nil
+}
+
+// growFile schedules extending the file to size.
+func (b *batch) growFile(size int64) {
+	b.grow = max(b.grow, size)
+}
+
+// readAt reads a page, seeing the writes of the active batch first.
+func (db *DB) readAt(buf []byte, off int64) error {
+	b := db.batch
+	if b != nil {
+		if page, ok := b.pages[off]; ok {
+			copy(buf, page)
+			return nil
+		}
+		if b.reset >= 0 && off >= HeaderPageSize {
+			clear(buf)
+			return nil
+		}
+	}
+	n, err := db.readCommitted(buf, off)
+	if errors.Is(err, io.EOF) && b != nil && off+int64(len(buf)) <= b.grow {
+		// Not written yet: the batch extends the file over it.
+		clear(buf[n:])
+		return nil
+	}
+	return err
+}
+
+// readCommitted reads pages as the last committed batch left them, ignoring the
+// active batch.
+func (db *DB) readCommitted(buf []byte, off int64) (int, error) {
+	if end := off + int64(len(buf)); end <= int64(len(db.mm)) {
+		return copy(buf, db.mm[off:end]), nil
+	}
+	if db.pool != nil {
+		if ok, err := db.pool.readAt(buf, off); ok || err != nil {
+			return len(buf), err
+		}
+	}
+	return db.f.ReadAt(buf, off)
+}
+
+// page returns the SlotSize bytes at off for reading only. It avoids a copy
+// where it can by returning the page buffered in the active batch or a slice of
+// the mapping, which stays valid until db.mu is released.
+func (db *DB) page(off int64) ([]byte, error) {
+	return db.pageInto(off, nil)
+}
+
+// pageInto is page that reads into buf, when it is SlotSize bytes long, rather
+// than a new buffer if the page has to come from the file.
+func (db *DB) pageInto(off int64, buf []byte) ([]byte, error) {
+	if b := db.batch; b != nil {
+		if page, ok := b.pages[off]; ok {
+			return page, nil
+		}
+	}
+	if end := off + SlotSize; end <= int64(len(db.mm)) && (db.batch == nil || db.batch.reset < 0 || off < HeaderPageSize) {
+		return db.mm[off:end:end], nil
+	}
+	if len(buf) != SlotSize {
+		buf = make([]byte, SlotSize)
+	}
+	return buf, db.readAt(buf, off)
+}
+
+// writeAt writes a page into the active batch, or straight to the file when
+// there is none (as when building a shadow file during resize).
+func (db *DB) writeAt(buf []byte, off int64) error {
+	if db.batch != nil {
+		db.batch.put(off, buf)
+		return nil
+	}
+	_, err := db.f.WriteAt(buf, off)
+	return err
+}
+
+// write runs fn as one atomic batch under the exclusive lock. If fn or the commit
+// fails, nothing reaches the file and the in-memory header and counters are restored.
+// Key and secondary index updates queued by fn are applied once the batch has committed.
+func (db *DB) write(fn func() error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.f == nil {
+		return os.ErrClosed
+	}
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	hdr, used, deleted := db.hdr, db.used, db.deleted
+	slots, probe := db.slots, db.probe
+	db.batch = newBatch()
+	err := fn()
+	if err == nil {
+		err = db.commit(db.batch)
+	}
+	db.batch = nil
+	ops, keyOps := db.idxOps, db.keyOps
+	db.idxOps, db.keyOps = nil, nil
+	if err != nil {
+		db.hdr, db.used, db.deleted = hdr, used, deleted
+		db.slots, db.probe = slots, probe
+		return err
+	}
+	if db.keys != nil {
+		db.applyKeyOps(keyOps)
+	}
+	db.applyIndexOps(ops)
+	if db.walSize >= db.opts.CheckpointBytes {
+		return db.checkpoint()
+	}
+	return nil
+}
+
+// commit logs b to the WAL and then applies it to the DB file.
+func (db *DB) commit(b *batch) error {
+	if b.reset < 0 && b.grow == 0 && len(b.order) == 0 {
+		return nil
+	}
+	if err := db.preserve(b); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if b.reset >= 0 {
+		body := binary.LittleEndian.AppendUint64(nil, uint64(b.reset))
+		appendWALRecord(&buf, recReset, body)
+	}
+	if b.grow > 0 {
+		body := binary.LittleEndian.AppendUint64(nil, uint64(b.grow))
+		appendWALRecord(&buf, recGrow, body)
+	}
+	for _, off := range b.order {
+		body := binary.LittleEndian.AppendUint64(make([]byte, 0, 8+SlotSize), uint64(off))
+		appendWALRecord(&buf, recPage, append(body, b.pages[off]...))
+	}
+	appendWALRecord(&buf, recCommit, binary.LittleEndian.AppendUint32(nil, db.hdr.generation()))
+	if _, err := db.wal.WriteAt(buf.Bytes(), db.walSize); err != nil {
+		return fmt.Errorf("wal: %w", err)
+	}
+	db.walSize += int64(buf.Len())
+	db.lsn += uint64(buf.Len())
+	if db.opts.Durability == DurabilitySync {
+		if err := db.wal.Sync(); err != nil {
+			return fmt.Errorf("wal: %w", err)
+		}
+	}
+	switch {
+	case db.mm != nil:
+		return db.applyMapped(b)
+	case db.pool != nil:
+		return db.applyPooled(b)
+	}
+	return applyBatch(db.f, b)
+}
+
+func appendWALRecord(buf *bytes.Buffer, kind byte, body []byte) {
+	var h [walRecHeader]byte
+	binary.LittleEndian.PutUint32(h[0:4], uint32(len(body)))
+	binary.LittleEndian.PutUint32(h[4:8], walChecksum(kind, body))
+	h[8] = kind
+	buf.Write(h[:])
+	buf.Write(body)
+}
+
+func walChecksum(kind byte, body []byte) uint32 {
+	return crc32.Update(crc32.Checksum([]byte{kind}, castagnoli), castagnoli, body)
+}
+
+func applyBatch(f *os.File, b *batch) error {
+	if b.reset >= 0 {
+		if err := f.Truncate(HeaderPageSize); err != nil {
+			return err
+		}
+		if err := f.Truncate(b.reset); err != nil {
+			return err
+		}
+	}
+	if b.grow > 0 {
+		st, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if st.Size() < b.grow {
+			if err := f.Truncate(b.grow); err != nil {
+				return err
+			}
+		}
+	}
+	for _, off := range b.order {
+		if _, err := f.WriteAt(b.pages[off], off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checkpoint writes back the buffer pool, flushes the DB file to stable storage
+// and empties the WAL.
+func (db *DB) Checkpoint() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.f == nil {
+		return os.ErrClosed
+	}
+	return db.checkpoint()
+}
+
+// checkpoint is Checkpoint for callers that hold db.mu exclusively.
+func (db *DB) checkpoint() error {
+	if db.walSize == 0 {
+		return nil
+	}
+	if db.mm != nil {
+		if err := msync(db.mm, true); err != nil {
+			return err
+		}
+	}
+	if db.pool != nil {
+		if err := db.pool.flush(); err != nil {
+			return err
+		}
+	}
+	if err := db.f.Sync(); err != nil {
+		return err
+	}
+	if err := db.wal.Truncate(0); err != nil {
+		return err
+	}
+	db.walSize = 0
+	db.group.markSynced(db.lsn)
+	return nil
+}
+
+// openWAL opens the log next to the DB file, replays committed batches into f and
+// checkpoints, leaving an empty log ready for appends.
+func openWAL(path string, f *os.File) (*os.File, error) {
+	wal, err := os.OpenFile(path+walSuffix, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	n, err := replayWAL(wal, f, fileGeneration(f))
+	if err != nil {
+		_ = wal.Close()
+		return nil, fmt.Errorf("wal replay: %w", err)
+	}
+	if n > 0 {
+		if err := f.Sync(); err != nil {
+			_ = wal.Close()
+			return nil, err
+		}
+	}
+	if err := wal.Truncate(0); err != nil {
+		_ = wal.Close()
+		return nil, err
+	}
+	return wal, nil
+}
+
+// checkWAL fails with ErrNeedsRecovery if the WAL of the DB at path holds
+// committed batches, which a read-only open cannot replay.
+func checkWAL(path string) error {
+	wal, err := os.Open(path + walSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer wal.Close()
+	gen := int64(-1)
+	if f, err := os.Open(path); err == nil {
+		gen = fileGeneration(f)
+		_ = f.Close()
+	}
+	n, err := replayWAL(wal, nil, gen)
+	if err != nil {
+		return fmt.Errorf("wal: %w", err)
+	}
+	if n > 0 {
+		return fmt.Errorf("%w: %s has %d committed batches", ErrNeedsRecovery, path+walSuffix, n)
+	}
+	return nil
+}
+
+// fileGeneration returns the header generation of the DB file f, or -1 when its
+// header does not decode, as when the log is about to repair it.
+func fileGeneration(f *os.File) int64 {
+	buf := make([]byte, HeaderPageSize)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		return -1
+	}
+	hdr, err := decodeHeader(buf)
+	if err != nil {
+		return -1
+	}
+	return int64(hdr.generation())
+}
+
+// replayWAL applies every committed batch in wal to f and returns how many were applied;
+// with a nil f it only counts them. Replay stops at the first torn or corrupt record;
+// everything after it was never committed. A commit stamped with another generation
+// than gen fails with ErrWALMismatch before its batch is applied; gen -1 skips the check.
+func replayWAL(wal, f *os.File, gen int64) (int, error) {
+	data, err := io.ReadAll(io.NewSectionReader(wal, 0, 1<<62))
+	if err != nil {
+		return 0, err
+	}
+	applied := 0
+	b := newBatch()
+	for len(data) >= walRecHeader {
+		n := int(binary.LittleEndian.Uint32(data[0:4]))
+		crc := binary.LittleEndian.Uint32(data[4:8])
+		kind := data[8]
+		if len(data) < walRecHeader+n {
+			break
+		}
+		body := data[walRecHeader : walRecHeader+n]
+		if walChecksum(kind, body) != crc {
+			break
+		}
+		data = data[walRecHeader+n:]
+		switch kind {
+		case recPage:
+			if n < 8 {
+				return applied, errors.New("short page record")
+			}
+			b.put(int64(binary.LittleEndian.Uint64(body[0:8])), body[8:])
+		case recReset:
+			if n != 8 {
+				return applied, errors.New("bad reset record")
+			}
+			b.resetFile(int64(binary.LittleEndian.Uint64(body)))
+		case recGrow:
+			if n != 8 {
+				return applied, errors.New("bad grow record")
+			}
+			b.growFile(int64(binary.LittleEndian.Uint64(body)))
+		case recCommit:
+			if n != 0 && n != 4 {
+				return applied, errors.New("bad commit record")
+			}
+			if n == 4 && gen >= 0 {
+				if got := binary.LittleEndian.Uint32(body); int64(got) != gen {
+					return applied, fmt.Errorf("%w: logged against generation %d, file is at %d", ErrWALMismatch, got, gen)
+				}
+			}
+			if f != nil {
+				if err := applyBatch(f, b); err != nil {
+					return applied, err
+				}
+			}
+			applied++
+			b = newBatch()
+		default:
+			return applied, fmt.Errorf("unknown record kind %d", kind)
+		}
+	}
+	return applied, nil
+}
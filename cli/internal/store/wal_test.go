@@ -0,0 +1,166 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// walCrash inserts a, checkpoints, then inserts each of keys and crashes. The
+// DB file is put back as the checkpoint left it, as if none of the later
+// batches had reached it, and the WAL holding them is returned.
+func walCrash(t *testing.T, path string, keys ...string) []byte {
+	t.Helper()
+	db, err := Create(path, CreateOptions{Slots: 31, Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		if err := db.Insert(key, i+2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	crash(db)
+	if err := os.WriteFile(path, before, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wal, err := os.ReadFile(path + walSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wal
+}
+
+// wantKeys fails unless exactly the keys marked true are found in db.
+func wantKeys(t *testing.T, db *DB, want map[string]bool) {
+	t.Helper()
+	for key, ok := range want {
+		var v int
+		found, err := db.Select(key, &v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != ok {
+			t.Fatalf("%s: found=%v, want %v", key, found, ok)
+		}
+	}
+}
+
+func TestWALReplaysCommittedBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.bin")
+	if wal := walCrash(t, path, "b"); len(wal) == 0 {
+		t.Fatal("the insert left nothing in the WAL")
+	}
+	db, err := Open(path, OpenOptions{Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	wantKeys(t, db, map[string]bool{"a": true, "b": true})
+	if st, err := os.Stat(path + walSuffix); err != nil || st.Size() != 0 {
+		t.Fatalf("WAL after replay: %v, %v", st, err)
+	}
+}
+
+func TestWALDiscardsTornBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.bin")
+	wal := walCrash(t, path, "b", "c")
+	// Cut into the commit record of the last batch.
+	if err := os.WriteFile(path+walSuffix, wal[:len(wal)-1], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkWAL(path); err == nil {
+		t.Fatal("checkWAL found no committed batch to recover")
+	}
+	db, err := Open(path, OpenOptions{Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	wantKeys(t, db, map[string]bool{"a": true, "b": true, "c": false})
+}
+
+func TestCheckpointTruncatesWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.bin")
+	db, err := Create(path, CreateOptions{Slots: 31, Options: Options{ReapInterval: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Insert(key, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st, err := db.Stats(); err != nil || st.WALBytes == 0 {
+		t.Fatalf("WAL before checkpoint: %d bytes, %v", st.WALBytes, err)
+	}
+	if err := db.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := db.Stats(); err != nil || st.WALBytes != 0 {
+		t.Fatalf("WAL after checkpoint: %d bytes, %v", st.WALBytes, err)
+	}
+	if st, err := os.Stat(path + walSuffix); err != nil || st.Size() != 0 {
+		t.Fatalf("WAL file after checkpoint: %v, %v", st, err)
+	}
+	// Nothing is left to replay: the records are in the DB file itself.
+	crash(db)
+	if err := os.Remove(path + walSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(path, OpenOptions{Options: Options{ReapInterval: -1}}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	wantKeys(t, db, map[string]bool{"a": true, "b": true, "c": true})
+}
+
+func TestWALRefusesOtherGeneration(t *testing.T) {
+	db := createTestDB(t, CreateOptions{Slots: 31})
+	if err := db.Insert("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(db.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.wmu.Lock()
+	err = db.resize(0)
+	db.wmu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("b", 2); err != nil {
+		t.Fatal(err)
+	}
+	// A crash that lost the rename leaves the old file next to a log written
+	// against the new one.
+	crash(db)
+	if err := os.WriteFile(db.path, before, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkWAL(db.path); !errors.Is(err, ErrWALMismatch) {
+		t.Fatalf("checkWAL: %v", err)
+	}
+	if _, err := Open(db.path, OpenOptions{Options: Options{ReapInterval: -1}}); !errors.Is(err, ErrWALMismatch) {
+		t.Fatalf("Open: %v", err)
+	}
+	if got, err := os.ReadFile(db.path); err != nil || !bytes.Equal(got, before) {
+		t.Fatalf("the refused log changed the file: %v", err)
+	}
+}
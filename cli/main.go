@@ -11,11 +11,28 @@ import (
     "path/filepath"
     "strconv"
     "strings"
+    "time"
 
 	"github.com/Kentoso/db-design-labs/internal/store"
 )
 
-const defaultDBPath = "data/db.bin"
+const (
+	defaultDBPath = "data/db.bin"
+	defaultSlots  = 5000
+)
+
+// tx is the transaction opened with "begin" in this session, if any.
+// While it is open, insert/update/delete/select go through it.
+var tx *store.Tx
+
+// readOnly is set by -readonly; the commands in mutating are then refused up front.
+var readOnly bool
+
+var mutating = map[string]bool{
+	"insert": true, "update": true, "upsert": true, "patch": true, "delete": true,
+	"begin": true, "commit": true, "clear": true, "vacuum": true,
+	"create-index": true, "drop-index": true, "restore": true,
+}
 
 // run represents a contiguous occupied region in the slot array
 type run struct{ start, length int }
@@ -23,18 +40,172 @@ type run struct{ start, length int }
 func usage() {
 	exe := filepath.Base(os.Args[0])
 	fmt.Fprintf(os.Stderr, "Usage:\n")
-	fmt.Fprintf(os.Stderr, "  %s [ -db path ] select <key>\n", exe)
-	fmt.Fprintf(os.Stderr, "  %s [ -db path ] insert <key> <json_payload>\n", exe)
-	fmt.Fprintf(os.Stderr, "  %s [ -db path ] delete <key>\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] [ -slots n ] <command>   (-slots is used when creating, checked when opening)\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] -readonly <command>   (shares the database with other readers; only reading commands)\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] select <key> [with-version]\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] insert <key> <json_payload> [ttl <duration>]\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] ttl <key>\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] update <key> <json_payload> [if-version <n>]\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] upsert <key> <json_payload>\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] patch <key> <json_merge_patch>\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] delete <key> [if-version <n>]\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] begin | commit | rollback\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] keys [prefix]\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] dump [prefix]\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] -key-index range <from|*> <to|*> [limit]   (keys in order, bounds inclusive)\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] create-index <name> <type|key_prefix|*> <json_path> [unique]\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] drop-index <name>\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] find <index> <value>   (value is JSON, or a bare string)\n", exe)
 	fmt.Fprintf(os.Stderr, "  %s [ -db path ] scan [threshold]\n", exe)
 	fmt.Fprintf(os.Stderr, "  %s [ -db path ] clear\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] info\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] sync   (make every committed write durable)\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] backup <path>   (consistent copy while the database stays in use)\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] restore <backup_path>   (replaces the database; it must not be open)\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] checkpoint\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] vacuum\n", exe)
+	fmt.Fprintf(os.Stderr, "  %s [ -db path ] verify\n", exe)
 }
 
 func main() {
 	dbPath := flag.String("db", defaultDBPath, "database file path")
+	slots := flag.Int("slots", defaultSlots, "slot count for a new database; checked against the header of an existing one when set explicitly")
+	maxLoad := flag.Float64("max-load", store.DefaultMaxLoadFactor, "load factor (occupied+deleted)/slots that triggers a resize; negative disables resizing")
+	growth := flag.Float64("growth", store.DefaultGrowthFactor, "slot count multiplier applied when the table grows")
+	hash := flag.String("hash", "fnv1a", "key hash function for a new database: fnv1a, siphash, xxhash64 or murmur3")
+	hashSeed := flag.Uint64("hash-seed", 0, "seed for a seeded hash function of a new database, expanded to 128 bits with SplitMix64 (0 picks a random one)")
+	probe := flag.String("probe", "linear", "collision resolution for a new database: linear, quadratic, double, robin-hood, cuckoo or linear-hashing")
+	deleteMode := flag.String("delete-mode", "tombstone", "how a new database frees deleted slots: tombstone or backward-shift (implied by -probe robin-hood)")
+	compression := flag.String("compression", "none", "record payload compression for a new database: none or deflate")
+	scrub := flag.Duration("scrub", 0, "verify the whole file in the background at this interval (0 disables)")
+	mmap := flag.Bool("mmap", false, "read the database file through a memory mapping instead of pread")
+	cachePages := flag.Int("cache-pages", 0, "cache the database file in a buffer pool of this many 4 KiB pages instead of reading it directly (0 disables)")
+	durability := flag.String("durability", "none", "when writes are fsynced to the WAL: none, sync (every write) or group (writers share an fsync)")
+	groupWindow := flag.Duration("group-window", 0, "how long a group commit waits for other writers (0 means the store default)")
+	flag.BoolVar(&readOnly, "readonly", false, "open the database read-only, sharing it with other readers; mutating commands are rejected")
+	lockTimeout := flag.Duration("lock-timeout", 0, "how long to wait for another process to release the database (0 fails at once)")
+	keyIndex := flag.Bool("key-index", false, "maintain an ordered key index (<db>.keys) for the range command")
 	flag.Parse()
 
-	db, err := store.Open(*dbPath, 5000)
+	var ha store.HashAlg
+	switch *hash {
+	case "fnv1a":
+		ha = store.HashFNV1a
+	case "siphash":
+		ha = store.HashSipHash
+	case "xxhash64":
+		ha = store.HashXXH64
+	case "murmur3":
+		ha = store.HashMurmur3
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -hash %q\n", *hash)
+		os.Exit(2)
+	}
+	var ps store.ProbeStrategy
+	switch *probe {
+	case "linear":
+		ps = store.ProbeLinear
+	case "quadratic":
+		ps = store.ProbeQuadratic
+	case "double":
+		ps = store.ProbeDouble
+	case "robin-hood":
+		ps = store.ProbeRobinHood
+	case "cuckoo":
+		ps = store.ProbeCuckoo
+	case "linear-hashing":
+		ps = store.ProbeLinearHashing
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -probe %q\n", *probe)
+		os.Exit(2)
+	}
+	var dm store.DeleteMode
+	switch *deleteMode {
+	case "tombstone":
+		dm = store.DeleteTombstone
+	case "backward-shift":
+		dm = store.DeleteBackwardShift
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -delete-mode %q\n", *deleteMode)
+		os.Exit(2)
+	}
+	var co store.Compression
+	switch *compression {
+	case "none":
+		co = store.CompressNone
+	case "deflate":
+		co = store.CompressDeflate
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -compression %q\n", *compression)
+		os.Exit(2)
+	}
+	var du store.Durability
+	switch *durability {
+	case "none":
+		du = store.DurabilityNone
+	case "sync":
+		du = store.DurabilitySync
+	case "group":
+		du = store.DurabilityGroup
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -durability %q\n", *durability)
+		os.Exit(2)
+	}
+
+	opts := store.OpenOptions{
+		CreateIfMissing: !readOnly,
+		ReadOnly:        readOnly,
+		Create:          store.CreateOptions{Slots: *slots, Hash: ha, HashSeed: store.ExpandSeed(*hashSeed), Probe: ps, DeleteMode: dm, Compression: co},
+		Options: store.Options{
+			MaxLoadFactor:     *maxLoad,
+			GrowthFactor:      *growth,
+			ScrubInterval:     *scrub,
+			KeyIndex:          *keyIndex,
+			Mmap:              *mmap,
+			CachePages:        *cachePages,
+			Durability:        du,
+			GroupCommitWindow: *groupWindow,
+			LockTimeout:       *lockTimeout,
+			OnScrub: func(r store.VerifyReport, err error) {
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "scrub: %v\n", err)
+				}
+				for _, c := range r.Corrupt {
+					fmt.Fprintf(os.Stderr, "scrub: %v\n", &c)
+				}
+			},
+		},
+	}
+	explicitDelete := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "slots" {
+			opts.Slots = *slots
+		}
+		if f.Name == "delete-mode" {
+			explicitDelete = true
+		}
+	})
+	if ps == store.ProbeRobinHood && !explicitDelete {
+		opts.Create.DeleteMode = store.DeleteBackwardShift
+	}
+	// restore replaces the file itself, so it runs instead of opening it.
+	if flag.Arg(0) == "restore" {
+		if readOnly {
+			fmt.Fprintln(os.Stderr, "restore: database opened read-only")
+			os.Exit(2)
+		}
+		if flag.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "restore requires <backup_path>")
+			os.Exit(2)
+		}
+		if err := store.Restore(*dbPath, flag.Arg(1), *lockTimeout); err != nil {
+			fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+		return
+	}
+	db, err := store.Open(*dbPath, opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "open: %v\n", err)
 		os.Exit(1)
@@ -85,6 +256,10 @@ func processLine(db *store.DB, line string) bool {
 	}
 	parts := strings.SplitN(line, " ", 3)
 	cmd := parts[0]
+	if readOnly && mutating[cmd] {
+		fmt.Fprintf(os.Stderr, "%s: database opened read-only\n", cmd)
+		return true
+	}
 	switch cmd {
 	case "insert":
 		if len(parts) < 3 {
@@ -92,14 +267,24 @@ func processLine(db *store.DB, line string) bool {
 			return true
 		}
 		key := parts[1]
-		payload := parts[2]
+		payload, ttl, withTTL := cutTTL(parts[2])
 		var tmp any
 		if err := json.Unmarshal([]byte(payload), &tmp); err != nil {
 			fmt.Fprintf(os.Stderr, "invalid json payload for key %s: %v\n", key, err)
 			return true
 		}
 		var raw json.RawMessage = json.RawMessage(payload)
-		if err := db.Insert(key, &raw); err != nil {
+		insert := db.Insert
+		if withTTL {
+			if tx != nil {
+				fmt.Fprintln(os.Stderr, "ttl is not supported inside a transaction")
+				return true
+			}
+			insert = func(key string, v any) error { return db.InsertWithTTL(key, v, ttl) }
+		} else if tx != nil {
+			insert = tx.Insert
+		}
+		if err := insert(key, &raw); err != nil {
 			if errors.Is(err, store.ErrKeyExists) {
 				fmt.Fprintln(os.Stderr, fmt.Sprintf("key %s exists", key))
 				return true
@@ -108,14 +293,99 @@ func processLine(db *store.DB, line string) bool {
 			return true
 		}
 		fmt.Println("ok")
+	case "ttl":
+		if len(parts) < 2 {
+			fmt.Fprintln(os.Stderr, "ttl requires <key>")
+			return true
+		}
+		left, ok, err := db.TTL(parts[1])
+		if errors.Is(err, store.ErrKeyNotFound) {
+			fmt.Fprintln(os.Stderr, "not found")
+			return true
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ttl: %v\n", err)
+			return true
+		}
+		if !ok {
+			fmt.Println("none")
+			return true
+		}
+		fmt.Println(left.Round(time.Millisecond))
+	case "update", "upsert", "patch":
+		if len(parts) < 3 {
+			fmt.Fprintf(os.Stderr, "%s requires <key> <json_payload>\n", cmd)
+			return true
+		}
+		key := parts[1]
+		payload, version, cas := cutIfVersion(parts[2])
+		if cas && cmd != "update" {
+			fmt.Fprintf(os.Stderr, "if-version is only supported by update and delete\n")
+			return true
+		}
+		if tx != nil && cas {
+			fmt.Fprintln(os.Stderr, "if-version is not supported inside a transaction")
+			return true
+		}
+		if tx != nil && cmd != "update" {
+			fmt.Fprintf(os.Stderr, "%s is not supported inside a transaction\n", cmd)
+			return true
+		}
+		if !json.Valid([]byte(payload)) {
+			fmt.Fprintf(os.Stderr, "invalid json payload for key %s\n", key)
+			return true
+		}
+		raw := json.RawMessage(payload)
+		var err error
+		switch cmd {
+		case "update":
+			switch {
+			case tx != nil:
+				err = tx.Update(key, &raw)
+			case cas:
+				err = db.CompareAndSwap(key, version, &raw)
+			default:
+				err = db.Update(key, &raw)
+			}
+		case "upsert":
+			err = db.Upsert(key, &raw)
+		case "patch":
+			err = db.Patch(key, raw)
+		}
+		var conflict *store.ErrVersionConflict
+		if errors.As(err, &conflict) {
+			fmt.Fprintf(os.Stderr, "version conflict: expected %d, found %d\n", conflict.Expected, conflict.Actual)
+			return true
+		}
+		if errors.Is(err, store.ErrKeyNotFound) {
+			fmt.Fprintln(os.Stderr, "not found")
+			return true
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", cmd, err)
+			return true
+		}
+		fmt.Println("ok")
 	case "select":
 		if len(parts) < 2 {
 			fmt.Fprintln(os.Stderr, "select requires <key>")
 			return true
 		}
 		key := parts[1]
+		withVersion := len(parts) == 3 && parts[2] == "with-version"
+		if tx != nil && withVersion {
+			fmt.Fprintln(os.Stderr, "with-version is not supported inside a transaction")
+			return true
+		}
 		var raw json.RawMessage
-		found, err := db.Select(key, &raw)
+		var version uint32
+		var found bool
+		var err error
+		if tx != nil {
+			found, err = tx.Select(key, &raw)
+		} else {
+			version, found, err = db.SelectVersion(key, &raw)
+		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "select: %v\n", err)
 			return true
@@ -124,6 +394,9 @@ func processLine(db *store.DB, line string) bool {
 			fmt.Fprintln(os.Stderr, "not found")
 			return true
 		}
+		if withVersion {
+			fmt.Printf("%d ", version)
+		}
 		if len(raw) == 0 {
 			fmt.Println("null")
 			return true
@@ -135,7 +408,34 @@ func processLine(db *store.DB, line string) bool {
 			return true
 		}
 		key := parts[1]
-		deleted, err := db.Delete(key)
+		if len(parts) == 3 {
+			_, version, cas := cutIfVersion(" " + parts[2])
+			if !cas {
+				fmt.Fprintln(os.Stderr, "delete accepts only <key> [if-version <n>]")
+				return true
+			}
+			if tx != nil {
+				fmt.Fprintln(os.Stderr, "if-version is not supported inside a transaction")
+				return true
+			}
+			err := db.DeleteIfVersion(key, version)
+			var conflict *store.ErrVersionConflict
+			if errors.As(err, &conflict) {
+				fmt.Fprintf(os.Stderr, "version conflict: expected %d, found %d\n", conflict.Expected, conflict.Actual)
+				return true
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "delete: %v\n", err)
+				return true
+			}
+			fmt.Println("ok")
+			return true
+		}
+		del := db.Delete
+		if tx != nil {
+			del = tx.Delete
+		}
+		deleted, err := del(key)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "delete: %v\n", err)
 			return true
@@ -172,6 +472,25 @@ func processLine(db *store.DB, line string) bool {
 		fmt.Printf("deleted %d\n", stats.Deleted)
 		fmt.Printf("total %d\n", stats.Total)
 		fmt.Printf("load_factor %.4f\n", lf)
+		fmt.Printf("avg_probe %.4f (max %d)\n", stats.AvgProbe, stats.MaxProbe)
+		fmt.Printf("resizes %d\n", stats.Resizes)
+		fmt.Printf("overflow_pages %d (free %d)\n", stats.OverflowPages, stats.OverflowFree)
+		fmt.Printf("wal_bytes %d\n", stats.WALBytes)
+		if stats.Compressed > 0 || db.Header().Compression != store.CompressNone {
+			ratio := 1.0
+			if stats.PayloadBytes > 0 {
+				ratio = float64(stats.StoredBytes) / float64(stats.PayloadBytes)
+			}
+			fmt.Printf("compression %s: %d of %d records, payload %d B stored %d B (ratio %.3f, saved %d B)\n",
+				db.Header().Compression, stats.Compressed, stats.Occupied, stats.PayloadBytes, stats.StoredBytes, ratio, stats.PayloadBytes-stats.StoredBytes)
+		}
+		if stats.Pool.Frames > 0 {
+			fmt.Printf("cache %d pages (dirty %d) hits %d misses %d evictions %d\n",
+				stats.Pool.Frames, stats.Pool.Dirty, stats.Pool.Hits, stats.Pool.Misses, stats.Pool.Evictions)
+		}
+		if stats.Corrupt > 0 {
+			fmt.Printf("corrupt %d (run verify for details)\n", stats.Corrupt)
+		}
         // Dense zones: contiguous occupied runs; report top 10 and persist all filtered
         var runs []run
 		for i := 0; i < len(states); {
@@ -219,18 +538,232 @@ func processLine(db *store.DB, line string) bool {
             end := filtered[i].start + filtered[i].length - 1
             fmt.Printf("zone %d start %d end %d length %d\n", i+1, filtered[i].start, end, filtered[i].length)
         }
+	case "keys", "dump":
+		var opts []store.ScanOption
+		if len(parts) >= 2 {
+			opts = append(opts, store.WithPrefix(parts[1]))
+		}
+		c := db.Scan(opts...)
+		n := 0
+		for key, data := range c.All() {
+			if cmd == "keys" {
+				fmt.Println(key)
+			} else {
+				fmt.Printf("%s %s\n", key, data)
+			}
+			n++
+		}
+		if err := c.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", cmd, err)
+			return true
+		}
+		fmt.Fprintf(os.Stderr, "%d records\n", n)
+	case "range":
+		args := strings.Fields(line)[1:]
+		if len(args) < 2 || len(args) > 3 {
+			fmt.Fprintln(os.Stderr, "range requires <from|*> <to|*> [limit]")
+			return true
+		}
+		for i := range args[:2] {
+			if args[i] == "*" {
+				args[i] = ""
+			}
+		}
+		limit := 0
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n <= 0 {
+				fmt.Fprintf(os.Stderr, "invalid limit %q\n", args[2])
+				return true
+			}
+			limit = n
+		}
+		keys, err := db.Range(args[0], args[1], limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "range: %v\n", err)
+			return true
+		}
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+		fmt.Fprintf(os.Stderr, "%d records\n", len(keys))
+	case "create-index":
+		args := strings.Fields(strings.Join(parts[1:], " "))
+		if len(args) < 3 || len(args) > 4 || (len(args) == 4 && args[3] != "unique") {
+			fmt.Fprintln(os.Stderr, "create-index requires <name> <type|key_prefix|*> <json_path> [unique]")
+			return true
+		}
+		match := args[1]
+		if match == "*" {
+			match = ""
+		}
+		if err := db.CreateIndex(args[0], match, args[2], len(args) == 4); err != nil {
+			fmt.Fprintf(os.Stderr, "create-index: %v\n", err)
+			return true
+		}
+		fmt.Println("ok")
+	case "drop-index":
+		if len(parts) < 2 {
+			fmt.Fprintln(os.Stderr, "drop-index requires <name>")
+			return true
+		}
+		if err := db.DropIndex(parts[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "drop-index: %v\n", err)
+			return true
+		}
+		fmt.Println("ok")
+	case "find":
+		if len(parts) < 3 {
+			fmt.Fprintln(os.Stderr, "find requires <index> <value>")
+			return true
+		}
+		var value any = parts[2]
+		if json.Valid([]byte(parts[2])) {
+			value = json.RawMessage(parts[2])
+		}
+		keys, err := db.Lookup(parts[1], value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "find: %v\n", err)
+			return true
+		}
+		for _, key := range keys {
+			var raw json.RawMessage
+			found, err := db.Select(key, &raw)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "find: %v\n", err)
+				return true
+			}
+			if found {
+				fmt.Printf("%s %s\n", key, raw)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%d records\n", len(keys))
+	case "begin":
+		if tx != nil {
+			fmt.Fprintln(os.Stderr, "transaction already open")
+			return true
+		}
+		tx = db.Begin()
+		fmt.Println("ok")
+	case "commit", "rollback":
+		if tx == nil {
+			fmt.Fprintln(os.Stderr, "no open transaction")
+			return true
+		}
+		var err error
+		if cmd == "commit" {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		tx = nil
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", cmd, err)
+			return true
+		}
+		fmt.Println("ok")
 	case "clear":
 		if err := db.Clear(); err != nil {
 			fmt.Fprintf(os.Stderr, "clear: %v\n", err)
 			return true
 		}
 		fmt.Println("ok")
+	case "sync":
+		if err := db.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "sync: %v\n", err)
+			return true
+		}
+		fmt.Println("ok")
+	case "backup":
+		if len(parts) < 2 {
+			fmt.Fprintln(os.Stderr, "backup requires <path>")
+			return true
+		}
+		if err := db.Snapshot(parts[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+			return true
+		}
+		fmt.Println("ok")
+	case "restore":
+		fmt.Fprintln(os.Stderr, "restore: run it on its own, as `-db path restore <backup_path>`, while nothing has the database open")
+	case "checkpoint":
+		if err := db.Checkpoint(); err != nil {
+			fmt.Fprintf(os.Stderr, "checkpoint: %v\n", err)
+			return true
+		}
+		fmt.Println("ok")
+	case "vacuum":
+		r, err := db.Vacuum()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "vacuum: %v\n", err)
+			return true
+		}
+		fmt.Printf("records %d\n", r.Records)
+		fmt.Printf("tombstones_removed %d\n", r.TombstonesRemoved)
+		fmt.Printf("avg_probe %.4f -> %.4f\n", r.AvgProbeBefore, r.AvgProbeAfter)
+		fmt.Printf("max_probe %d -> %d\n", r.MaxProbeBefore, r.MaxProbeAfter)
+	case "verify":
+		r, err := db.Verify()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "verify: %v\n", err)
+			return true
+		}
+		fmt.Printf("slots %d\n", r.Slots)
+		fmt.Printf("occupied %d\n", r.Occupied)
+		fmt.Printf("corrupt %d\n", len(r.Corrupt))
+		for _, c := range r.Corrupt {
+			fmt.Printf("slot %d hash %d: %s\n", c.Index, c.Hash, c.Reason)
+		}
+	case "info":
+		h := db.Header()
+		fmt.Printf("version %d\n", h.Version)
+		fmt.Printf("slot_size %d\n", h.SlotSize)
+		fmt.Printf("slots %d\n", h.Slots)
+		fmt.Printf("mod_prime %d\n", h.ModPrime)
+		fmt.Printf("hash %s\n", h.Hash)
+		fmt.Printf("probe %s\n", h.Probe)
+		fmt.Printf("delete_mode %s\n", h.Delete)
+		fmt.Printf("compression %s\n", h.Compression)
+		fmt.Printf("created %s\n", h.CreatedAt.Format(time.RFC3339))
+		for _, ix := range db.Indexes() {
+			unique := ""
+			if ix.Unique {
+				unique = " unique"
+			}
+			fmt.Printf("index %s match %q path %s%s\n", ix.Name, ix.Match, ix.Path, unique)
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n", cmd)
 	}
 	return true
 }
 
+// cutIfVersion splits a trailing "if-version <n>" clause off a command argument.
+func cutIfVersion(arg string) (string, uint32, bool) {
+	i := strings.LastIndex(arg, " if-version ")
+	if i < 0 {
+		return arg, 0, false
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(arg[i+len(" if-version "):]), 10, 32)
+	if err != nil {
+		return arg, 0, false
+	}
+	return strings.TrimSpace(arg[:i]), uint32(n), true
+}
+
+// cutTTL splits a trailing "ttl <duration>" clause off a command argument.
+func cutTTL(arg string) (string, time.Duration, bool) {
+	i := strings.LastIndex(arg, " ttl ")
+	if i < 0 {
+		return arg, 0, false
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(arg[i+len(" ttl "):]))
+	if err != nil || d <= 0 {
+		return arg, 0, false
+	}
+	return strings.TrimSpace(arg[:i]), d, true
+}
+
 func writeDenseZonesFile(path string, db *store.DB, runs []run) error {
     f, err := os.Create(path)
     if err != nil {